@@ -0,0 +1,437 @@
+// Copyright (c) 2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"os"
+	"unicode/utf8"
+
+	"github.com/Dadido3/noita-mapcap/pkg/stitch"
+	"github.com/tdewolff/canvas"
+)
+
+// annotationDisplayLineWidth is the stroke width of annotation lines and outlines.
+var annotationDisplayLineWidth = stitch.OverlaySize{Value: 2.0}
+
+// annotationDisplayIconRadius is the radius of the icons of point annotations.
+var annotationDisplayIconRadius = stitch.OverlaySize{Value: 6.0}
+
+// annotationDisplayFontSize is the font size of annotation labels in output pixels.
+var annotationDisplayFontSize = 14.0
+
+// annotationDefaultColor is used for annotations that don't specify a color.
+var annotationDefaultColor = color.NRGBA{255, 255, 0, 255}
+
+// annotationIcons contains the shapes of all supported point icons, centered at the origin.
+var annotationIcons = map[string]func(radius float64) *canvas.Path{
+	"circle":   func(r float64) *canvas.Path { return canvas.Circle(r) },
+	"square":   func(r float64) *canvas.Path { return canvas.Rectangle(2*r, 2*r).Translate(-r, -r) },
+	"diamond":  func(r float64) *canvas.Path { return canvas.RegularPolygon(4, r*1.2, true) },
+	"triangle": func(r float64) *canvas.Path { return canvas.RegularPolygon(3, r*1.2, false) },
+	"star":     func(r float64) *canvas.Path { return canvas.StarPolygon(5, r*1.3, r*0.6, false) },
+}
+
+// AnnotationKind defines the geometry of an annotation.
+type AnnotationKind int
+
+const (
+	AnnotationPoint    AnnotationKind = iota // A single point that is drawn as icon.
+	AnnotationPolyline                       // An open line through all points.
+	AnnotationPolygon                        // A closed and filled shape. Rectangles are stored as polygons.
+)
+
+// Annotation is a user defined marker, line or area on the map.
+type Annotation struct {
+	Kind        AnnotationKind
+	Label       string
+	Description string
+	Color       color.NRGBA
+	Icon        string       // Name of the icon, see annotationIcons. Only used by points.
+	Points      [][2]float64 // Points in world coordinates. The first point is where the label is placed.
+}
+
+type Annotations []Annotation
+
+// Bounds returns the bounding box of the annotation in world coordinates.
+func (a Annotation) Bounds() image.Rectangle {
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, point := range a.Points {
+		minX, minY = math.Min(minX, point[0]), math.Min(minY, point[1])
+		maxX, maxY = math.Max(maxX, point[0]), math.Max(maxY, point[1])
+	}
+
+	return image.Rect(int(math.Floor(minX)), int(math.Floor(minY)), int(math.Floor(maxX))+1, int(math.Floor(maxY))+1)
+}
+
+// validate checks the number of points and sets defaults.
+func (a *Annotation) validate() error {
+	minPoints := map[AnnotationKind]int{AnnotationPoint: 1, AnnotationPolyline: 2, AnnotationPolygon: 3}[a.Kind]
+	if len(a.Points) < minPoints {
+		return fmt.Errorf("annotation %q needs at least %d points, got %d", a.Label, minPoints, len(a.Points))
+	}
+
+	if a.Icon == "" {
+		a.Icon = "circle"
+	}
+	if _, ok := annotationIcons[a.Icon]; !ok {
+		return fmt.Errorf("annotation %q has unknown icon %q", a.Label, a.Icon)
+	}
+
+	return nil
+}
+
+// LoadAnnotations loads annotations from the JSON file at the given path.
+//
+// The file can either be a GeoJSON object with world coordinates, or a list of annotation records:
+//
+//	[
+//		{"type": "point", "label": "Secret room", "icon": "star", "color": "#ff00ff", "position": [1234, -567]},
+//		{"type": "rect", "label": "Shop", "rect": [100, 200, 300, 400]},
+//		{"type": "polyline", "label": "Way down", "points": [[0, 0], [100, 500], [200, 1000]]}
+//	]
+func LoadAnnotations(path string) (Annotations, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	data = bytes.TrimSpace(data)
+	var result Annotations
+	if bytes.HasPrefix(data, []byte("[")) {
+		result, err = parseAnnotationRecords(data)
+	} else {
+		result, err = parseGeoJSONAnnotations(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range result {
+		if err := result[i].validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// annotationRecord is a single entry of a plain annotation list.
+type annotationRecord struct {
+	Type        string       `json:"type"` // One of `point`, `rect`, `polyline` or `polygon`.
+	Label       string       `json:"label"`
+	Description string       `json:"description"`
+	Color       string       `json:"color"` // In the form `#rrggbb` or `#rrggbbaa`.
+	Icon        string       `json:"icon"`
+	Position    [2]float64   `json:"position"` // Used by points.
+	Rect        [4]float64   `json:"rect"`     // Left, top, right and bottom edge of rects.
+	Points      [][2]float64 `json:"points"`   // Used by polylines and polygons.
+}
+
+// parseAnnotationRecords parses a JSON list of annotationRecord.
+func parseAnnotationRecords(data []byte) (Annotations, error) {
+	var records []annotationRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, err
+	}
+
+	result := make(Annotations, 0, len(records))
+	for _, record := range records {
+		annotation := Annotation{Label: record.Label, Description: record.Description, Icon: record.Icon, Color: annotationDefaultColor}
+		if record.Color != "" {
+			var err error
+			if annotation.Color, err = parseHexColor(record.Color); err != nil {
+				return nil, fmt.Errorf("annotation %q: %w", record.Label, err)
+			}
+		}
+
+		switch record.Type {
+		case "point":
+			annotation.Kind, annotation.Points = AnnotationPoint, [][2]float64{record.Position}
+		case "rect":
+			left, top, right, bottom := record.Rect[0], record.Rect[1], record.Rect[2], record.Rect[3]
+			if left >= right || top >= bottom {
+				return nil, fmt.Errorf("annotation %q has an empty rect", record.Label)
+			}
+			annotation.Kind, annotation.Points = AnnotationPolygon, [][2]float64{{left, top}, {right, top}, {right, bottom}, {left, bottom}}
+		case "polyline":
+			annotation.Kind, annotation.Points = AnnotationPolyline, record.Points
+		case "polygon":
+			annotation.Kind, annotation.Points = AnnotationPolygon, record.Points
+		default:
+			return nil, fmt.Errorf("annotation %q has unknown type %q", record.Label, record.Type)
+		}
+
+		result = append(result, annotation)
+	}
+
+	return result, nil
+}
+
+// geoJSONObject contains the fields of all GeoJSON object types that are needed to read annotations.
+type geoJSONObject struct {
+	Type        string          `json:"type"`
+	Features    []geoJSONObject `json:"features"`    // Used by FeatureCollection.
+	Geometry    *geoJSONObject  `json:"geometry"`    // Used by Feature.
+	Properties  map[string]any  `json:"properties"`  // Used by Feature.
+	Geometries  []geoJSONObject `json:"geometries"`  // Used by GeometryCollection.
+	Coordinates json.RawMessage `json:"coordinates"` // Used by all other geometries, the structure depends on the type.
+}
+
+// property returns the first of the given properties that is a non empty string.
+func (o geoJSONObject) property(keys ...string) string {
+	for _, key := range keys {
+		if value, ok := o.Properties[key].(string); ok && value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// parseGeoJSONAnnotations parses a GeoJSON object.
+// Coordinates are expected to be world coordinates, with the y axis pointing down.
+//
+// The label, description, color and icon are taken from the properties of every feature.
+// Besides `label`, `description`, `color` and `icon`, the simplestyle properties `title`, `marker-color`, `stroke` and `marker-symbol` are supported as well.
+func parseGeoJSONAnnotations(data []byte) (Annotations, error) {
+	var root geoJSONObject
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+
+	var features []geoJSONObject
+	switch root.Type {
+	case "FeatureCollection":
+		features = root.Features
+	case "Feature":
+		features = []geoJSONObject{root}
+	default:
+		// A bare geometry.
+		features = []geoJSONObject{{Type: "Feature", Geometry: &root}}
+	}
+
+	var result Annotations
+	for _, feature := range features {
+		template := Annotation{
+			Label:       feature.property("label", "name", "title"),
+			Description: feature.property("description"),
+			Icon:        feature.property("icon", "marker-symbol"),
+			Color:       annotationDefaultColor,
+		}
+		if colorString := feature.property("color", "marker-color", "stroke"); colorString != "" {
+			var err error
+			if template.Color, err = parseHexColor(colorString); err != nil {
+				return nil, fmt.Errorf("feature %q: %w", template.Label, err)
+			}
+		}
+
+		if feature.Geometry == nil {
+			continue
+		}
+		annotations, err := feature.Geometry.annotations(template)
+		if err != nil {
+			return nil, fmt.Errorf("feature %q: %w", template.Label, err)
+		}
+		result = append(result, annotations...)
+	}
+
+	return result, nil
+}
+
+// annotations returns one annotation for every part of the geometry, based on the given template.
+func (o geoJSONObject) annotations(template Annotation) (Annotations, error) {
+	withPoints := func(kind AnnotationKind, positions [][]float64) (Annotation, error) {
+		annotation := template
+		annotation.Kind = kind
+		annotation.Points = make([][2]float64, 0, len(positions))
+		for _, position := range positions {
+			if len(position) < 2 {
+				return annotation, fmt.Errorf("invalid position %v", position)
+			}
+			annotation.Points = append(annotation.Points, [2]float64{position[0], position[1]})
+		}
+		// Rings in GeoJSON repeat the first position at the end.
+		if kind == AnnotationPolygon && len(annotation.Points) > 1 && annotation.Points[0] == annotation.Points[len(annotation.Points)-1] {
+			annotation.Points = annotation.Points[:len(annotation.Points)-1]
+		}
+		return annotation, nil
+	}
+
+	var result Annotations
+	add := func(kind AnnotationKind, positions ...[][]float64) error {
+		for _, p := range positions {
+			annotation, err := withPoints(kind, p)
+			if err != nil {
+				return err
+			}
+			result = append(result, annotation)
+		}
+		return nil
+	}
+
+	var err error
+	switch o.Type {
+	case "Point":
+		var coords []float64
+		if err = json.Unmarshal(o.Coordinates, &coords); err == nil {
+			err = add(AnnotationPoint, [][]float64{coords})
+		}
+	case "MultiPoint":
+		var coords [][]float64
+		if err = json.Unmarshal(o.Coordinates, &coords); err == nil {
+			for _, position := range coords {
+				if err = add(AnnotationPoint, [][]float64{position}); err != nil {
+					break
+				}
+			}
+		}
+	case "LineString":
+		var coords [][]float64
+		if err = json.Unmarshal(o.Coordinates, &coords); err == nil {
+			err = add(AnnotationPolyline, coords)
+		}
+	case "MultiLineString":
+		var coords [][][]float64
+		if err = json.Unmarshal(o.Coordinates, &coords); err == nil {
+			err = add(AnnotationPolyline, coords...)
+		}
+	case "Polygon":
+		// Only the outer ring is used, holes are ignored.
+		var coords [][][]float64
+		if err = json.Unmarshal(o.Coordinates, &coords); err == nil && len(coords) > 0 {
+			err = add(AnnotationPolygon, coords[0])
+		}
+	case "MultiPolygon":
+		var coords [][][][]float64
+		if err = json.Unmarshal(o.Coordinates, &coords); err == nil {
+			for _, polygon := range coords {
+				if len(polygon) > 0 {
+					if err = add(AnnotationPolygon, polygon[0]); err != nil {
+						break
+					}
+				}
+			}
+		}
+	case "GeometryCollection":
+		for _, geometry := range o.Geometries {
+			var annotations Annotations
+			if annotations, err = geometry.annotations(template); err != nil {
+				break
+			}
+			result = append(result, annotations...)
+		}
+	default:
+		err = fmt.Errorf("unsupported geometry type %q", o.Type)
+	}
+
+	return result, err
+}
+
+// AnnotationsOverlay draws user annotations over the stitched image.
+type AnnotationsOverlay struct {
+	annotations    Annotations
+	index          *SpatialIndex
+	maxLabelLength int // Length of the longest label in runes.
+}
+
+// NewAnnotationsOverlay returns an overlay that draws the given annotations.
+// This builds a spatial index of all annotations, which is used to find the annotations that need to be drawn.
+func NewAnnotationsOverlay(annotations Annotations) *AnnotationsOverlay {
+	bounds := make([]image.Rectangle, 0, len(annotations))
+	var maxLabelLength int
+	for _, annotation := range annotations {
+		bounds = append(bounds, annotation.Bounds())
+		maxLabelLength = max(maxLabelLength, utf8.RuneCountInString(annotation.Label))
+	}
+
+	return &AnnotationsOverlay{
+		annotations:    annotations,
+		index:          NewSpatialIndex(bounds, 512),
+		maxLabelLength: maxLabelLength,
+	}
+}
+
+// Draw implements the StitchedImageOverlay interface.
+func (a *AnnotationsOverlay) Draw(destImage *image.RGBA, scale stitch.OverlayScale) {
+	destRect := destImage.Bounds()
+	factor := 1 / float64(scale.Divider)
+	lineWidth := annotationDisplayLineWidth.OutputPixels(scale)
+	iconRadius := annotationDisplayIconRadius.OutputPixels(scale)
+
+	face := newOverlayFontFace(annotationDisplayFontSize)
+	defer face.Close()
+
+	// The rectangle of destImage in world coordinates.
+	// Extended by the size of icons and labels, as these are given in output pixels.
+	// The font is monospaced, so the width of the longest label is known without measuring every label.
+	advance, _ := measureOverlayText(face, "0")
+	margin := int(math.Ceil((iconRadius+lineWidth+float64(a.maxLabelLength)*advance+annotationDisplayFontSize)*float64(scale.Divider))) + 1
+	worldRect := image.Rectangle{destRect.Min.Mul(scale.Divider), destRect.Max.Mul(scale.Divider)}.Inset(-margin)
+
+	indices := a.index.Query(worldRect)
+	if len(indices) == 0 {
+		return
+	}
+
+	c, ctx := newOverlayCanvas(destImage)
+
+	for _, i := range indices {
+		annotation := a.annotations[i]
+		col := annotation.Color
+
+		ctx.ResetStyle()
+		ctx.SetStrokeWidth(lineWidth)
+		ctx.SetStrokeJoiner(canvas.RoundJoin)
+
+		switch annotation.Kind {
+		case AnnotationPoint:
+			ctx.SetFillColor(col)
+			ctx.SetStrokeColor(color.RGBA{0, 0, 0, 255})
+			ctx.SetStrokeWidth(math.Max(iconRadius/4, 1))
+			ctx.DrawPath(annotation.Points[0][0]*factor, annotation.Points[0][1]*factor, annotationIcons[annotation.Icon](iconRadius))
+
+		case AnnotationPolyline, AnnotationPolygon:
+			path := &canvas.Path{}
+			for j, point := range annotation.Points {
+				if j == 0 {
+					path.MoveTo(point[0]*factor, point[1]*factor)
+				} else {
+					path.LineTo(point[0]*factor, point[1]*factor)
+				}
+			}
+			ctx.SetFillColor(canvas.Transparent)
+			if annotation.Kind == AnnotationPolygon {
+				path.Close()
+				ctx.SetFillColor(color.NRGBA{col.R, col.G, col.B, col.A / 4})
+			}
+			ctx.SetStrokeColor(col)
+			ctx.DrawPath(0, 0, path)
+		}
+	}
+
+	renderOverlayCanvas(c, destImage)
+
+	// Labels are drawn on top of all shapes.
+	for _, i := range indices {
+		annotation := a.annotations[i]
+		if annotation.Label == "" {
+			continue
+		}
+		x, y := annotation.Points[0][0]*factor, annotation.Points[0][1]*factor
+		textColor := color.NRGBA{annotation.Color.R, annotation.Color.G, annotation.Color.B, 255}
+		if annotation.Kind == AnnotationPoint {
+			drawOverlayText(destImage, face, annotation.Label, x+iconRadius+2, y, 0, 0.5, textColor, color.Black)
+		} else {
+			drawOverlayText(destImage, face, annotation.Label, x+lineWidth+2, y+lineWidth+2, 0, 0, textColor, color.Black)
+		}
+	}
+}
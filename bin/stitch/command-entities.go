@@ -0,0 +1,256 @@
+// Copyright (c) 2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// entityInventoryRow is a single entity in the entity inventory.
+type entityInventoryRow struct {
+	X          float32  `json:"x"`
+	Y          float32  `json:"y"`
+	Name       string   `json:"name"`
+	Filename   string   `json:"filename"`
+	Tags       []string `json:"tags"`
+	Components []string `json:"components"`
+}
+
+// entityInventoryGroup is an area with the number of entities inside it.
+type entityInventoryGroup struct {
+	Group  string `json:"group"`
+	Left   int    `json:"left"`
+	Top    int    `json:"top"`
+	Right  int    `json:"right"`
+	Bottom int    `json:"bottom"`
+	Count  int    `json:"count"`
+}
+
+// runEntitiesCommand runs the `entities` command, which writes a table of all entities.
+func runEntitiesCommand(args []string) error {
+	flagSet := flag.NewFlagSet("entities", flag.ExitOnError)
+	flagEntitiesInputPath := flagSet.String("entities", filepath.Join(".", "..", "..", "output", "entities.json"), "The path to the entities.json file.")
+	flagEntityDedupTolerance := flagSet.Float64("entity-dedup-tolerance", 8, "Entities with the same filename, name and tags that are not farther apart than this distance in world pixels are considered duplicates. A negative value disables the deduplication.")
+	flagEntityDedupKeep := flagSet.String("entity-dedup-keep", "newest", "Which entity of a group of duplicates is kept. Either `newest` or `first`.")
+	flagTags := flagSet.String("tags", "", "Comma separated list of tags. Only entities with all of these tags are listed.")
+	flagComponents := flagSet.String("components", "", "Comma separated list of component types. Only entities with all of these components are listed. This includes the components of child entities.")
+	flagGroupBy := flagSet.String("group-by", "", "Output the number of entities per area instead of the entities themselves. Either `grid` or `regions`.")
+	flagGridSize := flagSet.Int("grid-size", 512, "The size of the grid cells in world pixels, when grouping by grid.")
+	flagRegions := flagSet.String("regions", "areas", "The path to a JSON file containing a list of regions, when grouping by regions. Use `areas` for the capture areas listed in AREAS.md.")
+	flagFormat := flagSet.String("format", "csv", "The output format. Either `csv` or `json`.")
+	flagOutputPath := flagSet.String("output", "-", "The path of the output file. Use `-` to write to stdout.")
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+
+	if *flagFormat != "csv" && *flagFormat != "json" {
+		return fmt.Errorf("unknown output format %q", *flagFormat)
+	}
+	if *flagGroupBy != "" && *flagGroupBy != "grid" && *flagGroupBy != "regions" {
+		return fmt.Errorf("unknown grouping %q", *flagGroupBy)
+	}
+	if *flagGroupBy == "grid" && *flagGridSize < 1 {
+		return fmt.Errorf("invalid grid size of %d", *flagGridSize)
+	}
+
+	entities, err := LoadEntities(*flagEntitiesInputPath)
+	if recordsErr := (*JSONRecordsError)(nil); errors.As(err, &recordsErr) {
+		log.Printf("Entities file is damaged: %v.", err)
+	} else if err != nil {
+		return fmt.Errorf("failed to load entities: %w", err)
+	}
+
+	if *flagEntityDedupTolerance >= 0 {
+		keep, err := ParseEntityDedupKeep(*flagEntityDedupKeep)
+		if err != nil {
+			return err
+		}
+		var removed int
+		entities, removed = entities.Deduplicate(*flagEntityDedupTolerance, keep)
+		log.Printf("Removed %v duplicate entities.", removed)
+	}
+
+	entities = ParseEntityFilter(*flagTags, *flagComponents).Filter(entities)
+	log.Printf("Got %v entities.", len(entities))
+
+	var output io.Writer = os.Stdout
+	if *flagOutputPath != "-" {
+		f, err := os.Create(*flagOutputPath)
+		if err != nil {
+			return fmt.Errorf("failed to create file: %w", err)
+		}
+		defer f.Close()
+		output = f
+	}
+
+	switch *flagGroupBy {
+	case "":
+		return writeEntityInventory(output, *flagFormat, entities)
+
+	case "grid":
+		return writeEntityInventoryGroups(output, *flagFormat, groupEntitiesByGrid(entities, *flagGridSize))
+
+	case "regions":
+		regions, err := LoadRegions(*flagRegions)
+		if err != nil {
+			return fmt.Errorf("failed to load regions: %w", err)
+		}
+		return writeEntityInventoryGroups(output, *flagFormat, groupEntitiesByRegions(entities, regions))
+	}
+
+	return fmt.Errorf("unknown grouping %q", *flagGroupBy)
+}
+
+// groupEntitiesByGrid counts the entities in every grid cell.
+// Only cells that contain entities are returned, sorted from top to bottom and left to right.
+func groupEntitiesByGrid(entities Entities, gridSize int) []entityInventoryGroup {
+	counts := map[image.Point]int{}
+	for _, entity := range entities {
+		cell := image.Point{int(math.Floor(float64(entity.Transform.X) / float64(gridSize))), int(math.Floor(float64(entity.Transform.Y) / float64(gridSize)))}
+		counts[cell]++
+	}
+
+	cells := make([]image.Point, 0, len(counts))
+	for cell := range counts {
+		cells = append(cells, cell)
+	}
+	slices.SortFunc(cells, func(a, b image.Point) int {
+		if a.Y != b.Y {
+			return a.Y - b.Y
+		}
+		return a.X - b.X
+	})
+
+	groups := make([]entityInventoryGroup, 0, len(cells))
+	for _, cell := range cells {
+		rect := image.Rect(cell.X*gridSize, cell.Y*gridSize, (cell.X+1)*gridSize, (cell.Y+1)*gridSize)
+		groups = append(groups, entityInventoryGroup{
+			Group:  fmt.Sprintf("%d,%d", rect.Min.X, rect.Min.Y),
+			Left:   rect.Min.X,
+			Top:    rect.Min.Y,
+			Right:  rect.Max.X,
+			Bottom: rect.Max.Y,
+			Count:  counts[cell],
+		})
+	}
+
+	return groups
+}
+
+// groupEntitiesByRegions counts the entities in every region.
+// Regions can overlap, so an entity may be counted in several regions.
+func groupEntitiesByRegions(entities Entities, regions Regions) []entityInventoryGroup {
+	groups := make([]entityInventoryGroup, 0, len(regions))
+	for _, region := range regions {
+		groups = append(groups, entityInventoryGroup{
+			Group:  region.Name,
+			Left:   region.Left,
+			Top:    region.Top,
+			Right:  region.Right,
+			Bottom: region.Bottom,
+		})
+	}
+
+	outside := 0
+	for _, entity := range entities {
+		inside := false
+		for i, region := range regions {
+			if region.Contains(float64(entity.Transform.X), float64(entity.Transform.Y)) {
+				groups[i].Count++
+				inside = true
+			}
+		}
+		if !inside {
+			outside++
+		}
+	}
+	if outside > 0 {
+		log.Printf("%v entities are outside of all regions.", outside)
+	}
+
+	return groups
+}
+
+// writeEntityInventory writes a table of the given entities in the given format.
+func writeEntityInventory(w io.Writer, format string, entities Entities) error {
+	rows := make([]entityInventoryRow, 0, len(entities))
+	for _, entity := range entities {
+		rows = append(rows, entityInventoryRow{
+			X:          entity.Transform.X,
+			Y:          entity.Transform.Y,
+			Name:       entity.Name,
+			Filename:   entity.Filename,
+			Tags:       entity.Tags,
+			Components: entity.ComponentTypes(),
+		})
+	}
+
+	switch format {
+	case "json":
+		jsonEnc := json.NewEncoder(w)
+		jsonEnc.SetIndent("", "\t")
+		return jsonEnc.Encode(rows)
+
+	case "csv":
+		csvWriter := csv.NewWriter(w)
+		csvWriter.Write([]string{"x", "y", "name", "filename", "tags", "components"})
+		for _, row := range rows {
+			csvWriter.Write([]string{
+				strconv.FormatFloat(float64(row.X), 'f', -1, 32),
+				strconv.FormatFloat(float64(row.Y), 'f', -1, 32),
+				row.Name,
+				row.Filename,
+				strings.Join(row.Tags, ";"),
+				strings.Join(row.Components, ";"),
+			})
+		}
+		csvWriter.Flush()
+		return csvWriter.Error()
+	}
+
+	return fmt.Errorf("unknown output format %q", format)
+}
+
+// writeEntityInventoryGroups writes a table of the given groups in the given format.
+func writeEntityInventoryGroups(w io.Writer, format string, groups []entityInventoryGroup) error {
+	switch format {
+	case "json":
+		jsonEnc := json.NewEncoder(w)
+		jsonEnc.SetIndent("", "\t")
+		return jsonEnc.Encode(groups)
+
+	case "csv":
+		csvWriter := csv.NewWriter(w)
+		csvWriter.Write([]string{"group", "left", "top", "right", "bottom", "count"})
+		for _, group := range groups {
+			csvWriter.Write([]string{
+				group.Group,
+				strconv.Itoa(group.Left),
+				strconv.Itoa(group.Top),
+				strconv.Itoa(group.Right),
+				strconv.Itoa(group.Bottom),
+				strconv.Itoa(group.Count),
+			})
+		}
+		csvWriter.Flush()
+		return csvWriter.Error()
+	}
+
+	return fmt.Errorf("unknown output format %q", format)
+}
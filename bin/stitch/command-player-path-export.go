@@ -0,0 +1,266 @@
+// Copyright (c) 2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"math"
+	"mime"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Dadido3/noita-mapcap/pkg/stitch"
+)
+
+// playerPathSVGOptions contains the options for exporting a player path as animated SVG.
+type playerPathSVGOptions struct {
+	Background      string  // Path of the background image. May be empty.
+	EmbedBackground bool    // Embed the background image into the SVG file, instead of linking it.
+	XMin, YMin      int     // Output coordinates of the top left corner of the background image, as given to the stitch tool.
+	ScaleDivider    int     // The downscaling factor of the background image.
+	Duration        float64 // Duration of the animation in seconds.
+	LineWidth       float64 // Line width in SVG pixels.
+	Color           string  // Line color.
+	TeleportDist    float64 // Path elements longer than this are not drawn as line.
+}
+
+// runPlayerPathExportCommand runs the `player-path-export` command, which writes the player path as CSV or animated SVG file.
+func runPlayerPathExportCommand(args []string) error {
+	flagSet := flag.NewFlagSet("player-path-export", flag.ExitOnError)
+	flagPlayerPathInputPath := flagSet.String("player-path", filepath.Join(".", "..", "..", "output", "player-path.json"), "The path to the player-path.json file.")
+	flagSimplify := flagSet.Float64("simplify", 0, "Simplify the player path, so that it doesn't deviate more than this distance in world pixels from the original path. 0 disables simplification.")
+	flagOutputPath := flagSet.String("output", filepath.Join(".", "player-path.svg"), "The path and filename of the resulting file. Supported formats/file extensions: `.csv`, `.svg`.")
+	flagBackground := flagSet.String("background", "", "The path to an image that is shown below the animated player path, usually a stitched image. Relative paths are resolved from the location of the SVG file.")
+	flagEmbedBackground := flagSet.Bool("embed-background", false, "Embed the background image into the SVG file, instead of linking to it.")
+	flagXMin := flagSet.Int("xmin", 0, "Left bound of the background image. Use the same value that was used to stitch the background image.")
+	flagYMin := flagSet.Int("ymin", 0, "Upper bound of the background image. Use the same value that was used to stitch the background image.")
+	flagScaleDivider := flagSet.Int("divide", 1, "The downscaling factor of the background image. Use the same value that was used to stitch the background image.")
+	flagDuration := flagSet.Float64("duration", 30, "The duration of the animation in seconds.")
+	flagLineWidth := flagSet.Float64("line-width", 3, "The line width of the player path in output pixels.")
+	flagColor := flagSet.String("color", "#ff4040", "The color of the player path.")
+	flagTeleportDistance := flagSet.Float64("teleport-distance", DefaultPlayerPathStyle.TeleportDistance, "The distance in world pixels the player has to travel at once to be counted as teleport. Teleports are not drawn as line.")
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+
+	fileExtension := strings.ToLower(filepath.Ext(*flagOutputPath))
+	if fileExtension != ".csv" && fileExtension != ".svg" {
+		return fmt.Errorf("unknown output format %q", fileExtension)
+	}
+	if *flagScaleDivider < 1 {
+		return fmt.Errorf("invalid scale of %v", *flagScaleDivider)
+	}
+	if *flagDuration <= 0 {
+		return fmt.Errorf("invalid duration of %v seconds", *flagDuration)
+	}
+
+	playerPath, err := LoadPlayerPath(*flagPlayerPathInputPath)
+	if recordsErr := (*JSONRecordsError)(nil); errors.As(err, &recordsErr) {
+		log.Printf("Player path file is damaged: %v.", err)
+	} else if err != nil {
+		return fmt.Errorf("failed to load player path: %w", err)
+	}
+	log.Printf("Got %v player path entries.", len(playerPath))
+	if len(playerPath) == 0 {
+		return fmt.Errorf("player path is empty")
+	}
+
+	if *flagSimplify > 0 {
+		playerPath = playerPath.Simplify(*flagSimplify)
+		log.Printf("Simplified player path to %v entries.", len(playerPath))
+	}
+
+	f, err := os.Create(*flagOutputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer f.Close()
+
+	bufWriter := bufio.NewWriter(f)
+
+	switch fileExtension {
+	case ".csv":
+		err = writePlayerPathCSV(bufWriter, playerPath)
+	case ".svg":
+		err = writePlayerPathSVG(bufWriter, playerPath, filepath.Dir(*flagOutputPath), playerPathSVGOptions{
+			Background:      *flagBackground,
+			EmbedBackground: *flagEmbedBackground,
+			XMin:            *flagXMin,
+			YMin:            *flagYMin,
+			ScaleDivider:    *flagScaleDivider,
+			Duration:        *flagDuration,
+			LineWidth:       *flagLineWidth,
+			Color:           *flagColor,
+			TeleportDist:    *flagTeleportDistance,
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	return bufWriter.Flush()
+}
+
+// writePlayerPathCSV writes all player path elements as CSV table.
+func writePlayerPathCSV(w io.Writer, playerPath PlayerPath) error {
+	formatFloat := func(f float64) string { return strconv.FormatFloat(f, 'f', -1, 64) }
+
+	csvWriter := csv.NewWriter(w)
+	csvWriter.Write([]string{"fromX", "fromY", "toX", "toY", "hp", "maxHP", "polymorphed", "frame"})
+	for _, pathElement := range playerPath {
+		csvWriter.Write([]string{
+			formatFloat(pathElement.From[0]),
+			formatFloat(pathElement.From[1]),
+			formatFloat(pathElement.To[0]),
+			formatFloat(pathElement.To[1]),
+			formatFloat(pathElement.HP),
+			formatFloat(pathElement.MaxHP),
+			strconv.FormatBool(pathElement.Polymorphed),
+			strconv.FormatInt(pathElement.Frame, 10),
+		})
+	}
+	csvWriter.Flush()
+
+	return csvWriter.Error()
+}
+
+// writePlayerPathSVG writes the player path as SVG file, which replays the path as animation.
+//
+// The animation progresses with the elapsed game time, or with the traveled distance if the path doesn't contain any timing information.
+// svgDir is the directory the SVG file is written to, which is used to resolve the path of the background image.
+func writePlayerPathSVG(w io.Writer, playerPath PlayerPath, svgDir string, opts playerPathSVGOptions) error {
+	factor := 1 / float64(opts.ScaleDivider)
+
+	// Determine the area of the SVG in output coordinates.
+	var viewX, viewY, viewW, viewH float64
+	var backgroundHref string
+	if opts.Background != "" {
+		backgroundPath := opts.Background
+		if !filepath.IsAbs(backgroundPath) {
+			backgroundPath = filepath.Join(svgDir, backgroundPath)
+		}
+		width, height, err := stitch.GetImageFileDimension(backgroundPath)
+		if err != nil {
+			return err
+		}
+		viewX, viewY = float64(opts.XMin), float64(opts.YMin)
+		viewW, viewH = float64(width), float64(height)
+
+		backgroundHref = filepath.ToSlash(opts.Background)
+		if opts.EmbedBackground {
+			data, err := os.ReadFile(backgroundPath)
+			if err != nil {
+				return fmt.Errorf("failed to read background image: %w", err)
+			}
+			mimeType := mime.TypeByExtension(filepath.Ext(backgroundPath))
+			if mimeType == "" {
+				mimeType = "application/octet-stream"
+			}
+			backgroundHref = "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(data)
+		}
+	} else {
+		// Use the bounding box of the player path.
+		bounds := playerPath[0].Bounds()
+		for _, pathElement := range playerPath {
+			bounds = bounds.Union(pathElement.Bounds())
+		}
+		margin := 4 * opts.LineWidth
+		viewX, viewY = float64(bounds.Min.X)*factor-margin, float64(bounds.Min.Y)*factor-margin
+		viewW, viewH = float64(bounds.Dx())*factor+2*margin, float64(bounds.Dy())*factor+2*margin
+	}
+
+	// Build the path data, and the progress of the animation at every point.
+	// Teleports and unconnected elements are drawn as move, so they don't have any length.
+	var pathData strings.Builder
+	var lengths, progress []float64 // Cumulative length and progress in the range [0, 1] at the end of every path element.
+	elapsed := playerPath.ElapsedFrames()
+	totalLength := 0.0
+	formatCoord := func(f float64) string { return strconv.FormatFloat(f, 'f', 2, 64) }
+	for i, pathElement := range playerPath {
+		if i == 0 || pathElement.From != playerPath[i-1].To {
+			fmt.Fprintf(&pathData, "M%s,%s", formatCoord(pathElement.From[0]*factor), formatCoord(pathElement.From[1]*factor))
+		}
+		if pathElement.Length() >= opts.TeleportDist {
+			fmt.Fprintf(&pathData, "M%s,%s", formatCoord(pathElement.To[0]*factor), formatCoord(pathElement.To[1]*factor))
+		} else {
+			fmt.Fprintf(&pathData, "L%s,%s", formatCoord(pathElement.To[0]*factor), formatCoord(pathElement.To[1]*factor))
+			totalLength += pathElement.Length() * factor
+		}
+		lengths = append(lengths, totalLength)
+	}
+	for i := range playerPath {
+		switch {
+		case elapsed != nil && elapsed[len(elapsed)-1] > 0:
+			progress = append(progress, float64(elapsed[i])/float64(elapsed[len(elapsed)-1]))
+		case totalLength > 0:
+			progress = append(progress, lengths[i]/totalLength)
+		default:
+			progress = append(progress, float64(i+1)/float64(len(playerPath)))
+		}
+	}
+
+	// The key times and points of the animation.
+	// Both have to start with 0 and end with 1.
+	var keyTimes, keyPoints []string
+	keyTimes, keyPoints = append(keyTimes, "0"), append(keyPoints, "0")
+	for i := range playerPath {
+		keyTimes = append(keyTimes, strconv.FormatFloat(progress[i], 'f', 5, 64))
+		point := 1.0
+		if totalLength > 0 {
+			point = lengths[i] / totalLength
+		}
+		keyPoints = append(keyPoints, strconv.FormatFloat(point, 'f', 5, 64))
+	}
+	keyTimes[len(keyTimes)-1], keyPoints[len(keyPoints)-1] = "1", "1"
+
+	// The dash offset that hides the part of the path that is not reached yet.
+	dashValues := make([]string, 0, len(keyPoints))
+	dashValues = append(dashValues, formatCoord(totalLength))
+	for i := range playerPath {
+		dashValues = append(dashValues, formatCoord(totalLength-lengths[i]))
+	}
+	dashValues[len(dashValues)-1] = "0"
+
+	var err error
+	printf := func(format string, a ...any) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format, a...)
+		}
+	}
+
+	printf("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	printf("<svg xmlns=\"http://www.w3.org/2000/svg\" xmlns:xlink=\"http://www.w3.org/1999/xlink\" width=\"%s\" height=\"%s\" viewBox=\"%s %s %s %s\">\n",
+		formatCoord(viewW), formatCoord(viewH), formatCoord(viewX), formatCoord(viewY), formatCoord(viewW), formatCoord(viewH))
+	if backgroundHref != "" {
+		printf("\t<image x=\"%s\" y=\"%s\" width=\"%s\" height=\"%s\" href=\"%s\" xlink:href=\"%s\"/>\n",
+			formatCoord(viewX), formatCoord(viewY), formatCoord(viewW), formatCoord(viewH), html.EscapeString(backgroundHref), html.EscapeString(backgroundHref))
+	}
+	printf("\t<path id=\"player-path\" d=\"%s\" fill=\"none\" stroke=\"%s\" stroke-width=\"%s\" stroke-linecap=\"round\" stroke-linejoin=\"round\" stroke-dasharray=\"%s\" stroke-dashoffset=\"%s\">\n",
+		pathData.String(), html.EscapeString(opts.Color), formatCoord(opts.LineWidth), formatCoord(totalLength+1), formatCoord(totalLength))
+	printf("\t\t<animate attributeName=\"stroke-dashoffset\" dur=\"%gs\" fill=\"freeze\" calcMode=\"linear\" values=\"%s\" keyTimes=\"%s\"/>\n",
+		opts.Duration, strings.Join(dashValues, ";"), strings.Join(keyTimes, ";"))
+	printf("\t</path>\n")
+	printf("\t<circle r=\"%s\" fill=\"%s\" stroke=\"#000000\" stroke-width=\"%s\">\n",
+		formatCoord(math.Max(opts.LineWidth*2, 3)), html.EscapeString(opts.Color), formatCoord(math.Max(opts.LineWidth/2, 1)))
+	printf("\t\t<animateMotion dur=\"%gs\" fill=\"freeze\" calcMode=\"linear\" keyPoints=\"%s\" keyTimes=\"%s\">\n",
+		opts.Duration, strings.Join(keyPoints, ";"), strings.Join(keyTimes, ";"))
+	printf("\t\t\t<mpath href=\"#player-path\" xlink:href=\"#player-path\"/>\n")
+	printf("\t\t</animateMotion>\n")
+	printf("\t</circle>\n")
+	printf("</svg>\n")
+
+	return err
+}
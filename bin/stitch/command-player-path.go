@@ -0,0 +1,251 @@
+// Copyright (c) 2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// playerPathFramesPerSecond is the number of game frames per second.
+const playerPathFramesPerSecond = 60
+
+// playerPathHPDrop is a single event where the player lost a lot of HP at once.
+type playerPathHPDrop struct {
+	Index  int     `json:"index"`
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	HPLoss float64 `json:"hpLoss"`
+	HP     float64 `json:"hp"`
+	MaxHP  float64 `json:"maxHP"`
+}
+
+// playerPathRegionStats contains statistics about the part of the player path inside a region.
+type playerPathRegionStats struct {
+	Name     string  `json:"name"`
+	Segments int     `json:"segments"`
+	Frames   int64   `json:"frames"`
+	Distance float64 `json:"distance"`
+}
+
+// playerPathStats contains statistics about a player path.
+type playerPathStats struct {
+	Segments         int     `json:"segments"`
+	Distance         float64 `json:"distance"` // Distance traveled without teleports.
+	Teleports        int     `json:"teleports"`
+	TeleportDistance float64 `json:"teleportDistance"`
+
+	HasFrames bool  `json:"hasFrames"` // If false, all frame counts are 0, as the player path doesn't contain any timing information.
+	Frames    int64 `json:"frames"`
+
+	Polymorphs          int   `json:"polymorphs"`
+	PolymorphedSegments int   `json:"polymorphedSegments"`
+	PolymorphedFrames   int64 `json:"polymorphedFrames"`
+
+	HPLoss  float64            `json:"hpLoss"` // The sum of all HP that was lost, regardless of any healing.
+	HPDrops []playerPathHPDrop `json:"hpDrops"`
+
+	Regions []playerPathRegionStats `json:"regions"`
+
+	DeepestX     float64 `json:"deepestX"`
+	DeepestY     float64 `json:"deepestY"`
+	DeepestIndex int     `json:"deepestIndex"`
+}
+
+// runPlayerPathCommand runs the `player-path` command, which writes statistics about the player path.
+func runPlayerPathCommand(args []string) error {
+	flagSet := flag.NewFlagSet("player-path", flag.ExitOnError)
+	flagPlayerPathInputPath := flagSet.String("player-path", filepath.Join(".", "..", "..", "output", "player-path.json"), "The path to the player-path.json file.")
+	flagRegions := flagSet.String("regions", "areas", "The path to a JSON file containing a list of regions. Use `areas` for the capture areas listed in AREAS.md.")
+	flagHPDrop := flagSet.Float64("hp-drop", DefaultPlayerPathStyle.HPDropThreshold, "The fraction of the maximum HP that the player has to lose at once to be listed.")
+	flagTeleportDistance := flagSet.Float64("teleport-distance", DefaultPlayerPathStyle.TeleportDistance, "The distance in world pixels the player has to travel at once to be counted as teleport.")
+	flagFormat := flagSet.String("format", "text", "The output format. Either `text` or `json`.")
+	flagOutputPath := flagSet.String("output", "-", "The path of the output file. Use `-` to write to stdout.")
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+
+	if *flagFormat != "text" && *flagFormat != "json" {
+		return fmt.Errorf("unknown output format %q", *flagFormat)
+	}
+
+	playerPath, err := LoadPlayerPath(*flagPlayerPathInputPath)
+	if recordsErr := (*JSONRecordsError)(nil); errors.As(err, &recordsErr) {
+		log.Printf("Player path file is damaged: %v.", err)
+	} else if err != nil {
+		return fmt.Errorf("failed to load player path: %w", err)
+	}
+	log.Printf("Got %v player path entries.", len(playerPath))
+
+	regions, err := LoadRegions(*flagRegions)
+	if err != nil {
+		return fmt.Errorf("failed to load regions: %w", err)
+	}
+
+	stats := analyzePlayerPath(playerPath, regions, *flagHPDrop, *flagTeleportDistance)
+
+	var output io.Writer = os.Stdout
+	if *flagOutputPath != "-" {
+		f, err := os.Create(*flagOutputPath)
+		if err != nil {
+			return fmt.Errorf("failed to create file: %w", err)
+		}
+		defer f.Close()
+		output = f
+	}
+
+	switch *flagFormat {
+	case "json":
+		jsonEnc := json.NewEncoder(output)
+		jsonEnc.SetIndent("", "\t")
+		return jsonEnc.Encode(stats)
+
+	case "text":
+		return writePlayerPathStatsText(output, stats)
+	}
+
+	return fmt.Errorf("unknown output format %q", *flagFormat)
+}
+
+// analyzePlayerPath returns statistics about the given player path.
+//
+// A path element is considered to be inside a region, if its end point is inside.
+// Path elements that are longer than teleportDistance are not counted towards any distance except the teleport distance.
+func analyzePlayerPath(playerPath PlayerPath, regions Regions, hpDropThreshold, teleportDistance float64) playerPathStats {
+	stats := playerPathStats{
+		Segments:  len(playerPath),
+		HasFrames: playerPath.HasFrames(),
+		HPDrops:   []playerPathHPDrop{},
+		Regions:   make([]playerPathRegionStats, 0, len(regions)),
+	}
+
+	for _, region := range regions {
+		stats.Regions = append(stats.Regions, playerPathRegionStats{Name: region.Name})
+	}
+
+	durations := playerPath.DurationFrames()
+	if durations == nil {
+		durations = make([]int64, len(playerPath))
+	}
+
+	for i, pathElement := range playerPath {
+		length := pathElement.Length()
+		teleport := length >= teleportDistance
+		if !teleport {
+			stats.Distance += length
+		}
+		stats.Frames += durations[i]
+
+		if pathElement.Polymorphed {
+			stats.PolymorphedSegments++
+			stats.PolymorphedFrames += durations[i]
+		}
+
+		if i > 0 && playerPath[i-1].HP > pathElement.HP && pathElement.MaxHP > 0 {
+			stats.HPLoss += playerPath[i-1].HP - pathElement.HP
+		}
+
+		for j, region := range regions {
+			if region.Contains(pathElement.To[0], pathElement.To[1]) {
+				stats.Regions[j].Segments++
+				stats.Regions[j].Frames += durations[i]
+				if !teleport {
+					stats.Regions[j].Distance += length
+				}
+			}
+		}
+
+		// Noita's y axis points downwards.
+		if i == 0 || pathElement.To[1] > stats.DeepestY {
+			stats.DeepestX, stats.DeepestY, stats.DeepestIndex = pathElement.To[0], pathElement.To[1], i
+		}
+	}
+
+	for _, event := range playerPath.Events(hpDropThreshold, teleportDistance) {
+		switch event.Kind {
+		case PlayerPathEventTeleport:
+			stats.Teleports++
+			stats.TeleportDistance += PlayerPathElement{From: event.Position, To: event.Destination}.Length()
+
+		case PlayerPathEventPolymorphStart:
+			stats.Polymorphs++
+
+		case PlayerPathEventHPDrop:
+			pathElement := playerPath[event.Index]
+			stats.HPDrops = append(stats.HPDrops, playerPathHPDrop{
+				Index:  event.Index,
+				X:      event.Position[0],
+				Y:      event.Position[1],
+				HPLoss: event.HPLoss,
+				HP:     pathElement.HP,
+				MaxHP:  pathElement.MaxHP,
+			})
+		}
+	}
+
+	// A player path that starts polymorphed also counts as polymorph.
+	if len(playerPath) > 0 && playerPath[0].Polymorphed {
+		stats.Polymorphs++
+	}
+
+	return stats
+}
+
+// formatPlayerPathFrames returns the given number of frames as human readable duration.
+func formatPlayerPathFrames(frames int64) string {
+	return (time.Duration(frames) * time.Second / playerPathFramesPerSecond).Round(time.Second).String()
+}
+
+// writePlayerPathStatsText writes the given statistics in a human readable form.
+func writePlayerPathStatsText(w io.Writer, stats playerPathStats) error {
+	// formatTime returns the time for the given segments and frames.
+	// If there are no frames, it will just return the number of segments.
+	formatTime := func(segments int, frames int64) string {
+		if !stats.HasFrames {
+			return fmt.Sprintf("%d segments", segments)
+		}
+		return fmt.Sprintf("%s (%d segments)", formatPlayerPathFrames(frames), segments)
+	}
+
+	var err error
+	printf := func(format string, a ...any) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format, a...)
+		}
+	}
+
+	printf("Segments:          %d\n", stats.Segments)
+	if stats.HasFrames {
+		printf("Time:              %s\n", formatPlayerPathFrames(stats.Frames))
+	} else {
+		printf("Time:              unknown, the player path doesn't contain any timing information\n")
+	}
+	printf("Distance:          %.0f\n", stats.Distance)
+	printf("Teleports:         %d (%.0f distance)\n", stats.Teleports, stats.TeleportDistance)
+	printf("Polymorphs:        %d\n", stats.Polymorphs)
+	printf("Time polymorphed:  %s\n", formatTime(stats.PolymorphedSegments, stats.PolymorphedFrames))
+	printf("HP lost:           %.0f\n", stats.HPLoss)
+	printf("Deepest position:  %.0f, %.0f (segment %d)\n", stats.DeepestX, stats.DeepestY, stats.DeepestIndex)
+
+	printf("\nHP drops: %d\n", len(stats.HPDrops))
+	for _, drop := range stats.HPDrops {
+		printf("  Segment %d at %.0f, %.0f: Lost %.0f HP, %.0f of %.0f HP left\n", drop.Index, drop.X, drop.Y, drop.HPLoss, drop.HP, drop.MaxHP)
+	}
+
+	printf("\nRegions:\n")
+	for _, region := range stats.Regions {
+		printf("  %s: %s, %.0f distance\n", region.Name, formatTime(region.Segments, region.Frames), region.Distance)
+	}
+
+	return err
+}
@@ -0,0 +1,185 @@
+// Copyright (c) 2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"image"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Dadido3/noita-mapcap/pkg/stitch"
+)
+
+// dziViewerTemplate is an HTML page that shows a DZI with OpenSeadragon.
+// It adds clickable markers for annotations, and checkboxes to toggle overlay layers that are stacked on top of the DZI.
+//
+// The DZI descriptors are embedded into the page, and tiles are loaded as images.
+// This way the page also works when it's opened directly from the file system.
+var dziViewerTemplate = template.Must(template.New("viewer").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<script src="https://cdn.jsdelivr.net/npm/openseadragon@4.1/build/openseadragon/openseadragon.min.js"></script>
+<style>
+html, body { margin: 0; height: 100%; background: #000; }
+#viewer { width: 100%; height: 100%; }
+.marker { width: 24px; height: 24px; border-radius: 50%; cursor: pointer; }
+.marker:hover { box-shadow: 0 0 0 2px #fff; }
+#popup { display: none; position: absolute; top: 10px; right: 10px; max-width: 320px; padding: 8px 12px; background: rgba(0, 0, 0, 0.8); color: #fff; font-family: sans-serif; white-space: pre-wrap; }
+#popup .label { font-weight: bold; }
+#popup .position { color: #aaa; }
+#layers { position: absolute; top: 10px; left: 10px; padding: 8px 12px; background: rgba(0, 0, 0, 0.8); color: #fff; font-family: sans-serif; }
+#layers:empty { display: none; }
+#layers label { display: block; }
+</style>
+</head>
+<body>
+<div id="viewer"></div>
+<div id="popup"></div>
+<div id="layers"></div>
+<script>
+const markers = {{.Markers}};
+const layers = {{.Layers}};
+const viewer = OpenSeadragon({
+	id: "viewer",
+	prefixUrl: "https://cdn.jsdelivr.net/npm/openseadragon@4.1/build/openseadragon/images/",
+	tileSources: {{.TileSources}},
+});
+const popup = document.getElementById("popup");
+const layerList = document.getElementById("layers");
+
+// The first item of the world is the DZI itself, followed by the layers.
+layers.forEach((name, i) => {
+	const checkbox = document.createElement("input");
+	checkbox.type = "checkbox";
+	checkbox.checked = true;
+	checkbox.addEventListener("change", () => viewer.world.getItemAt(i + 1).setOpacity(checkbox.checked ? 1 : 0));
+	const label = document.createElement("label");
+	label.append(checkbox, " " + name);
+	layerList.appendChild(label);
+});
+
+function showPopup(marker) {
+	popup.replaceChildren();
+	for (const [className, text] of [["label", marker.label], ["description", marker.description], ["position", "x: " + marker.worldX + ", y: " + marker.worldY]]) {
+		if (text) {
+			const element = document.createElement("div");
+			element.className = className;
+			element.textContent = text;
+			popup.appendChild(element);
+		}
+	}
+	popup.style.display = "block";
+}
+
+viewer.addHandler("open", () => {
+	for (const marker of markers) {
+		const element = document.createElement("div");
+		element.className = "marker";
+		element.title = marker.label;
+		viewer.addOverlay({element: element, location: viewer.viewport.imageToViewportCoordinates(marker.x, marker.y), placement: OpenSeadragon.Placement.CENTER});
+		new OpenSeadragon.MouseTracker({element: element, clickHandler: () => showPopup(marker)});
+	}
+});
+viewer.addHandler("canvas-click", event => {
+	if (event.quick) {
+		popup.style.display = "none";
+	}
+});
+</script>
+</body>
+</html>
+`))
+
+// dziViewerMarker is a clickable marker in the viewer.
+type dziViewerMarker struct {
+	X           float64 `json:"x"` // Position in DZI image pixels.
+	Y           float64 `json:"y"` // Position in DZI image pixels.
+	WorldX      float64 `json:"worldX"`
+	WorldY      float64 `json:"worldY"`
+	Label       string  `json:"label"`
+	Description string  `json:"description"`
+}
+
+// exportDZIViewer writes an HTML page next to the DZI descriptor at dziPath, which shows the DZI with a clickable marker for every annotation.
+// The marker of an annotation is placed at its first point, where its label is drawn.
+//
+// The layers with the given names are stacked on top of the DZI, and can be toggled.
+// They are expected to have the same geometry as the DZI, and to be placed next to it, see layerOutputPath.
+func exportDZIViewer(dziPath string, dzi stitch.DZI, annotations Annotations, layerNames []string) error {
+	outputPath := strings.TrimSuffix(dziPath, filepath.Ext(dziPath)) + ".html"
+
+	log.Printf("Creating DZI viewer %q.", outputPath)
+
+	bounds, scaleDivider := dzi.Bounds(), dzi.ScaleDivider()
+
+	markers := make([]dziViewerMarker, 0, len(annotations))
+	for _, annotation := range annotations {
+		anchor := annotation.Points[0]
+		imagePoint := dziImagePoint(anchor, bounds, scaleDivider)
+		markers = append(markers, dziViewerMarker{
+			X:           imagePoint[0],
+			Y:           imagePoint[1],
+			WorldX:      anchor[0],
+			WorldY:      anchor[1],
+			Label:       annotation.Label,
+			Description: annotation.Description,
+		})
+	}
+
+	// Same as the DZI descriptor, but with the location of the tiles.
+	tileSource := func(path string) any {
+		return map[string]any{
+			"Image": map[string]any{
+				"xmlns":    "http://schemas.microsoft.com/deepzoom/2008",
+				"Url":      filepath.Base(strings.TrimSuffix(path, filepath.Ext(path))) + "_files/",
+				"Format":   strings.TrimPrefix(dzi.FileExtension(), "."),
+				"Overlap":  fmt.Sprint(dzi.Overlap()),
+				"TileSize": fmt.Sprint(dzi.TileSize()),
+				"Size":     map[string]string{"Width": fmt.Sprint(bounds.Dx()), "Height": fmt.Sprint(bounds.Dy())},
+			},
+		}
+	}
+
+	tileSources := []any{tileSource(dziPath)}
+	for _, layerName := range layerNames {
+		tileSources = append(tileSources, tileSource(layerOutputPath(dziPath, layerName)))
+	}
+
+	f, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer f.Close()
+
+	data := struct {
+		Title       string
+		TileSources []any
+		Markers     []dziViewerMarker
+		Layers      []string
+	}{
+		Title:       filepath.Base(dziPath),
+		TileSources: tileSources,
+		Markers:     markers,
+		Layers:      append([]string{}, layerNames...), // Never nil, so it's a list in JavaScript.
+	}
+
+	if err := dziViewerTemplate.Execute(f, data); err != nil {
+		return fmt.Errorf("failed to write viewer: %w", err)
+	}
+
+	return f.Close()
+}
+
+// dziImagePoint converts world coordinates into DZI image pixels.
+func dziImagePoint(world [2]float64, bounds image.Rectangle, scaleDivider int) [2]float64 {
+	return [2]float64{world[0]/float64(scaleDivider) - float64(bounds.Min.X), world[1]/float64(scaleDivider) - float64(bounds.Min.Y)}
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"slices"
+	"strings"
+)
+
+// EntityDedupKeep defines which entity of a group of duplicates is kept.
+type EntityDedupKeep int
+
+const (
+	EntityDedupKeepNewest    EntityDedupKeep = iota // Keep the entity that was written last.
+	EntityDedupKeepFirstSeen                        // Keep the entity that was written first.
+)
+
+// ParseEntityDedupKeep returns the mode that corresponds to the given name.
+func ParseEntityDedupKeep(name string) (EntityDedupKeep, error) {
+	switch name {
+	case "newest":
+		return EntityDedupKeepNewest, nil
+	case "first":
+		return EntityDedupKeepFirstSeen, nil
+	}
+
+	return 0, fmt.Errorf("unknown deduplication mode %q", name)
+}
+
+// identity returns a string that identifies the entity regardless of its position.
+// Two entities with the same identity and a similar position are considered to be the same entity.
+func (e Entity) identity() string {
+	tags := slices.Clone(e.Tags)
+	slices.Sort(tags)
+
+	return e.Filename + "\x00" + e.Name + "\x00" + strings.Join(tags, ",")
+}
+
+// Deduplicate returns a list of entities where all duplicates are removed.
+//
+// Entities are duplicates if they have the same filename, name and tags, and their positions are not farther apart than the given tolerance in world pixels.
+// Which entity of a group of duplicates is kept is determined by keep.
+// The order of the remaining entities is not changed.
+//
+// This also returns the number of removed duplicates.
+func (e Entities) Deduplicate(tolerance float64, keep EntityDedupKeep) (Entities, int) {
+	// Group already kept entities by their position in a grid, so only nearby entities have to be compared.
+	cellSize := max(tolerance, 1)
+	type cellEntry struct {
+		identity string
+		x, y     float64
+	}
+	cells := map[image.Point][]cellEntry{}
+
+	isDuplicate := func(identity string, x, y float64) bool {
+		cellX, cellY := int(math.Floor(x/cellSize)), int(math.Floor(y/cellSize))
+		for iy := cellY - 1; iy <= cellY+1; iy++ {
+			for ix := cellX - 1; ix <= cellX+1; ix++ {
+				for _, entry := range cells[image.Point{ix, iy}] {
+					if entry.identity == identity && math.Hypot(entry.x-x, entry.y-y) <= tolerance {
+						return true
+					}
+				}
+			}
+		}
+
+		cell := image.Point{cellX, cellY}
+		cells[cell] = append(cells[cell], cellEntry{identity: identity, x: x, y: y})
+		return false
+	}
+
+	kept := make([]bool, len(e))
+	removed := 0
+	for i := range e {
+		// The entities are in the order they were written, so iterate backwards to keep the newest ones.
+		index := i
+		if keep == EntityDedupKeepNewest {
+			index = len(e) - 1 - i
+		}
+
+		entity := e[index]
+		if isDuplicate(entity.identity(), float64(entity.Transform.X), float64(entity.Transform.Y)) {
+			removed++
+			continue
+		}
+		kept[index] = true
+	}
+
+	result := make(Entities, 0, len(e)-removed)
+	for i, entity := range e {
+		if kept[i] {
+			result = append(result, entity)
+		}
+	}
+
+	return result, removed
+}
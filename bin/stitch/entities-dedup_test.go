@@ -0,0 +1,79 @@
+// Copyright (c) 2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestEntitiesDeduplicate(t *testing.T) {
+	// entity returns an entity whose identity is made of id and tags.
+	entity := func(id string, x, y float32, tags ...string) Entity {
+		return Entity{Filename: "data/entities/" + id + ".xml", Name: "enemy", Tags: tags, Transform: EntityTransform{X: x, Y: y}}
+	}
+	// duplicate returns a copy of the given entity at a different position.
+	duplicate := func(e Entity, x, y float32) Entity {
+		e.Transform.X, e.Transform.Y = x, y
+		return e
+	}
+
+	a := entity("a", 5, 5, "enemy", "mortal")
+
+	tests := []struct {
+		name        string
+		entities    Entities
+		tolerance   float64
+		wantNewest  []int // Indices of the kept entities with EntityDedupKeepNewest.
+		wantFirst   []int // Indices of the kept entities with EntityDedupKeepFirstSeen.
+		wantRemoved int
+	}{
+		{name: "same position", entities: Entities{a, a}, tolerance: 0,
+			wantNewest: []int{1}, wantFirst: []int{0}, wantRemoved: 1},
+		{name: "exactly at tolerance across cell border", entities: Entities{a, duplicate(a, 15, 5)}, tolerance: 10,
+			wantNewest: []int{1}, wantFirst: []int{0}, wantRemoved: 1},
+		{name: "exactly at tolerance across negative cell border", entities: Entities{duplicate(a, -8, 0), duplicate(a, 2, 0)}, tolerance: 10,
+			wantNewest: []int{1}, wantFirst: []int{0}, wantRemoved: 1},
+		{name: "exactly at tolerance diagonally", entities: Entities{duplicate(a, 9, 9), duplicate(a, 15, 17)}, tolerance: 10,
+			wantNewest: []int{1}, wantFirst: []int{0}, wantRemoved: 1},
+		{name: "beyond tolerance", entities: Entities{a, duplicate(a, 15.5, 5)}, tolerance: 10,
+			wantNewest: []int{0, 1}, wantFirst: []int{0, 1}},
+		{name: "different identity", entities: Entities{a, entity("b", 5, 5, "enemy", "mortal")}, tolerance: 10,
+			wantNewest: []int{0, 1}, wantFirst: []int{0, 1}},
+		{name: "different tags", entities: Entities{a, entity("a", 5, 5, "enemy")}, tolerance: 10,
+			wantNewest: []int{0, 1}, wantFirst: []int{0, 1}},
+		{name: "tags in different order", entities: Entities{a, entity("a", 6, 5, "mortal", "enemy")}, tolerance: 10,
+			wantNewest: []int{1}, wantFirst: []int{0}, wantRemoved: 1},
+		{name: "chain is only compared against kept entities", entities: Entities{duplicate(a, 0, 0), duplicate(a, 8, 0), duplicate(a, 16, 0)}, tolerance: 10,
+			wantNewest: []int{0, 2}, wantFirst: []int{0, 2}, wantRemoved: 1},
+		{name: "order is kept", entities: Entities{a, entity("b", 0, 0), duplicate(a, 6, 6), entity("c", 0, 0)}, tolerance: 10,
+			wantNewest: []int{1, 2, 3}, wantFirst: []int{0, 1, 3}, wantRemoved: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, mode := range []struct {
+				keep EntityDedupKeep
+				want []int
+			}{{EntityDedupKeepNewest, tt.wantNewest}, {EntityDedupKeepFirstSeen, tt.wantFirst}} {
+				result, removed := tt.entities.Deduplicate(tt.tolerance, mode.keep)
+				if removed != tt.wantRemoved {
+					t.Errorf("Keep mode %d: Got %d removed entities, want %d", mode.keep, removed, tt.wantRemoved)
+				}
+
+				var want Entities
+				for _, i := range mode.want {
+					want = append(want, tt.entities[i])
+				}
+				if !slices.EqualFunc(result, want, func(a, b Entity) bool {
+					return a.Filename == b.Filename && a.Transform == b.Transform
+				}) {
+					t.Errorf("Keep mode %d: Got %v, want %v", mode.keep, result, want)
+				}
+			}
+		})
+	}
+}
@@ -6,58 +6,113 @@
 package main
 
 import (
-	"encoding/json"
 	"image"
+	"image/color"
+	"math"
 	"os"
 
+	"github.com/Dadido3/noita-mapcap/pkg/stitch"
 	"github.com/tdewolff/canvas"
-	"github.com/tdewolff/canvas/renderers/rasterizer"
 )
 
 type Entities []Entity
 
+// LoadEntities loads the entities from the JSON file at the given path.
+//
+// The file is decoded record by record, see DecodeJSONRecords.
+// If the file is damaged, all records before the damaged part are returned along with a *JSONRecordsError.
 func LoadEntities(path string) (Entities, error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
+	defer file.Close()
 
-	var result Entities
+	return DecodeJSONRecords[Entity](file)
+}
 
-	jsonDec := json.NewDecoder(file)
-	if err := jsonDec.Decode(&result); err != nil {
-		return nil, err
+// EntitiesOverlay draws entities over the stitched image.
+type EntitiesOverlay struct {
+	entities Entities
+	index    *SpatialIndex
+}
+
+// NewEntitiesOverlay returns an overlay that draws the given entities.
+// This builds a spatial index of all entities, which is used to find the entities that need to be drawn.
+func NewEntitiesOverlay(entities Entities) *EntitiesOverlay {
+	bounds := make([]image.Rectangle, 0, len(entities))
+	for _, entity := range entities {
+		bounds = append(bounds, entity.Bounds())
 	}
 
-	return result, nil
+	return &EntitiesOverlay{
+		entities: entities,
+		index:    NewSpatialIndex(bounds, 512),
+	}
 }
 
 // Draw implements the StitchedImageOverlay interface.
-func (e Entities) Draw(destImage *image.RGBA) {
+func (e *EntitiesOverlay) Draw(destImage *image.RGBA, scale stitch.OverlayScale) {
 	destRect := destImage.Bounds()
 
-	// Same as destImage, but top left is translated to (0, 0).
-	originImage := destImage.SubImage(destRect).(*image.RGBA)
-	originImage.Rect = originImage.Rect.Sub(destRect.Min)
+	// The rectangle of destImage in world coordinates.
+	// Extended by the size of markers and lines, as these are given in output pixels.
+	margin := int(math.Ceil((entityDisplayMarkerRadius.OutputPixels(scale)+entityDisplayLineWidth.OutputPixels(scale))*float64(scale.Divider))) + 1
+	worldRect := image.Rectangle{destRect.Min.Mul(scale.Divider), destRect.Max.Mul(scale.Divider)}.Inset(-margin)
+
+	c, ctx := newOverlayCanvas(destImage)
+
+	for _, i := range e.index.Query(worldRect) {
+		e.entities[i].Draw(ctx, scale)
+	}
+
+	renderOverlayCanvas(c, destImage)
+}
 
-	c := canvas.New(float64(destRect.Dx()), float64(destRect.Dy()))
-	ctx := canvas.NewContext(c)
-	ctx.SetCoordSystem(canvas.CartesianIV)
-	ctx.SetCoordRect(canvas.Rect{X: -float64(destRect.Min.X), Y: -float64(destRect.Min.Y), W: float64(destRect.Dx()), H: float64(destRect.Dy())}, float64(destRect.Dx()), float64(destRect.Dy()))
+// entityLegendStyles contains the legend labels of all component shape styles, in the order they appear in the legend.
+var entityLegendStyles = []struct {
+	label string
+	style canvas.Style
+}{
+	{"Area damage", entityDisplayAreaDamageStyle},
+	{"Material area checker", entityDisplayMaterialAreaCheckerStyle},
+	{"Teleport area", entityDisplayTeleportStyle},
+	{"Hit box", entityDisplayHitBoxStyle},
+	{"Collision trigger", entityDisplayCollisionTriggerStyle},
+}
+
+// LegendEntries implements the LegendProvider interface.
+// Only shape styles that are used by any of the entities are listed.
+func (e *EntitiesOverlay) LegendEntries() []LegendEntry {
+	if len(e.entities) == 0 {
+		return nil
+	}
 
-	// Set drawing style.
-	ctx.Style = playerPathDisplayStyle
+	used := map[color.Color]bool{}
+	var collect func(entity Entity)
+	collect = func(entity Entity) {
+		for _, shape := range entity.shapes() {
+			used[shape.style.Fill.Color] = true
+		}
+		for _, child := range entity.Children {
+			collect(child)
+		}
+	}
+	for _, entity := range e.entities {
+		collect(entity)
+	}
 
-	for _, entity := range e {
-		// Check if entity origin is near or around the current image rectangle.
-		entityOrigin := image.Point{int(entity.Transform.X), int(entity.Transform.Y)}
-		if entityOrigin.In(destRect.Inset(-512)) {
-			entity.Draw(ctx)
+	entries := []LegendEntry{{
+		Label: "Entity",
+		Symbol: func(c *canvas.Context, x, y, size float64) {
+			drawEntityMarker(c, x, y, size/3, math.Max(size/10, 1))
+		},
+	}}
+	for _, legendStyle := range entityLegendStyles {
+		if used[legendStyle.style.Fill.Color] {
+			entries = append(entries, LegendEntry{Label: legendStyle.label, Symbol: legendSwatch(legendStyle.style)})
 		}
 	}
 
-	// Theoretically we would need to linearize imgRGBA first, but DefaultColorSpace assumes that the color space is linear already.
-	r := rasterizer.FromImage(originImage, canvas.DPMM(1.0), canvas.DefaultColorSpace)
-	c.RenderTo(r)
-	r.Close() // This just transforms the image's luminance curve back from linear into non linear.
+	return entries
 }
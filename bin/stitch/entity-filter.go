@@ -0,0 +1,90 @@
+// Copyright (c) 2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+// EntityFilter selects entities by their tags and components.
+// The zero value matches every entity.
+type EntityFilter struct {
+	Tags           []string // The entity must have all of these tags.
+	ComponentTypes []string // The entity or any of its children must have components of all these types.
+}
+
+// ParseEntityFilter creates a filter from comma separated lists of tags and component types.
+func ParseEntityFilter(tags, componentTypes string) EntityFilter {
+	return EntityFilter{
+		Tags:           splitList(tags),
+		ComponentTypes: splitList(componentTypes),
+	}
+}
+
+// Match returns whether the given entity passes the filter.
+func (f EntityFilter) Match(e Entity) bool {
+	for _, tag := range f.Tags {
+		if !slices.Contains(e.Tags, tag) {
+			return false
+		}
+	}
+
+	if len(f.ComponentTypes) > 0 {
+		componentTypes := e.ComponentTypes()
+		for _, componentType := range f.ComponentTypes {
+			if !slices.Contains(componentTypes, componentType) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// Filter returns all entities that pass the filter.
+func (f EntityFilter) Filter(entities Entities) Entities {
+	var result Entities
+	for _, entity := range entities {
+		if f.Match(entity) {
+			result = append(result, entity)
+		}
+	}
+
+	return result
+}
+
+// ComponentTypes returns the types of all components of the entity and its children.
+// Every type is only listed once, in the order of its first occurrence.
+func (e Entity) ComponentTypes() []string {
+	var result []string
+	for _, component := range e.Components {
+		if !slices.Contains(result, component.TypeName) {
+			result = append(result, component.TypeName)
+		}
+	}
+	for _, child := range e.Children {
+		for _, componentType := range child.ComponentTypes() {
+			if !slices.Contains(result, componentType) {
+				result = append(result, componentType)
+			}
+		}
+	}
+
+	return result
+}
+
+// splitList splits a comma separated list, and removes any empty entries.
+func splitList(list string) []string {
+	var result []string
+	for _, entry := range strings.Split(list, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			result = append(result, entry)
+		}
+	}
+
+	return result
+}
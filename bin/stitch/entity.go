@@ -6,14 +6,27 @@
 package main
 
 import (
+	"image"
 	"image/color"
+	"math"
 
+	"github.com/Dadido3/noita-mapcap/pkg/stitch"
 	"github.com/tdewolff/canvas"
 )
 
 //var entityDisplayFontFamily = canvas.NewFontFamily("times")
 //var entityDisplayFontFace *canvas.FontFace
 
+// entityDisplayLineWidth is the stroke width of all entity component shapes.
+var entityDisplayLineWidth = stitch.OverlaySize{Value: 1.0}
+
+// entityDisplayMarkerRadius is the radius of the marker that is drawn at every entity's origin.
+var entityDisplayMarkerRadius = stitch.OverlaySize{Value: 3.0}
+
+// entityDisplaySimplifyZoomOut defines at which zoom out factor only entity markers are drawn.
+// Component shapes become too small to be useful at this point.
+const entityDisplaySimplifyZoomOut = 4
+
 var entityDisplayAreaDamageStyle = canvas.Style{
 	Fill:         canvas.Paint{Color: color.RGBA{100, 0, 0, 100}},
 	Stroke:       canvas.Paint{},
@@ -96,13 +109,39 @@ type EntityTransform struct {
 	Rotation float32 `json:"rotation"`
 }
 
+// LocalMatrix returns the rotation and scale part of the transform.
+// The result maps entity local coordinates to world coordinates relative to the entity origin.
+func (t EntityTransform) LocalMatrix() canvas.Matrix {
+	scaleX, scaleY := float64(t.ScaleX), float64(t.ScaleY)
+	// A missing scale is treated as unscaled.
+	// This is done per axis, as a zero scale would collapse the shapes into a line.
+	if scaleX == 0 {
+		scaleX = 1
+	}
+	if scaleY == 0 {
+		scaleY = 1
+	}
+
+	// Noita uses radians, canvas uses degrees.
+	// As both use y-down coordinates here, the rotation direction is the same.
+	return canvas.Identity.Rotate(float64(t.Rotation)*180/math.Pi).Scale(scaleX, scaleY)
+}
+
 type Component struct {
 	TypeName string         `json:"typeName"`
 	Members  map[string]any `json:"members"`
 }
 
-func (e Entity) Draw(c *canvas.Context) {
-	x, y := float64(e.Transform.X), float64(e.Transform.Y)
+// entityShape is a shape that represents an entity component.
+type entityShape struct {
+	style canvas.Style
+	path  *canvas.Path // The shape in entity local coordinates.
+}
+
+// shapes returns the shapes of all known components of the entity.
+// This doesn't include the shapes of any child entity.
+func (e Entity) shapes() []entityShape {
+	var shapes []entityShape
 
 	for _, component := range e.Components {
 		switch component.TypeName {
@@ -120,18 +159,24 @@ func (e Entity) Draw(c *canvas.Context) {
 					aabbMaxY, _ = aabbMax[1].(float64)
 				}
 			}
-			if aabbMinX < aabbMaxX && aabbMinY < aabbMaxY {
-				c.Style = entityDisplayAreaDamageStyle
-				c.DrawPath(x+aabbMinX, y+aabbMinY, canvas.Rectangle(aabbMaxX-aabbMinX, aabbMaxY-aabbMinY))
-			}
+			var radius float64
 			if member, ok := component.Members["circle_radius"]; ok {
-				if radius, ok := member.(float64); ok && radius > 0 {
-					// Theoretically we need to clip the damage area to the intersection of the AABB and the circle, but meh.
-					// TODO: Clip the area to the intersection of the box and the circle
-					cx, cy := (aabbMinX+aabbMaxX)/2, (aabbMinY+aabbMaxY)/2
-					c.Style = entityDisplayAreaDamageStyle
-					c.DrawPath(x+cx, y+cy, canvas.Circle(radius))
-				}
+				radius, _ = member.(float64)
+			}
+			// The damage area is the intersection of the AABB and the circle, if both are given.
+			// The circle is centered in the AABB.
+			path := &canvas.Path{}
+			switch {
+			case aabbMinX < aabbMaxX && aabbMinY < aabbMaxY && radius > 0:
+				cx, cy := (aabbMinX+aabbMaxX)/2, (aabbMinY+aabbMaxY)/2
+				path = RectangleCircleIntersection(aabbMinX, aabbMinY, aabbMaxX, aabbMaxY, cx, cy, radius)
+			case aabbMinX < aabbMaxX && aabbMinY < aabbMaxY:
+				path = canvas.Rectangle(aabbMaxX-aabbMinX, aabbMaxY-aabbMinY).Translate(aabbMinX, aabbMinY)
+			case radius > 0:
+				path = canvas.Circle(radius)
+			}
+			if !path.Empty() {
+				shapes = append(shapes, entityShape{style: entityDisplayAreaDamageStyle, path: path})
 			}
 
 		case "MaterialAreaCheckerComponent": // Checks for materials in the given AABB.
@@ -145,8 +190,7 @@ func (e Entity) Draw(c *canvas.Context) {
 				}
 			}
 			if aabbMinX < aabbMaxX && aabbMinY < aabbMaxY {
-				c.Style = entityDisplayMaterialAreaCheckerStyle
-				c.DrawPath(x+aabbMinX, y+aabbMinY, canvas.Rectangle(aabbMaxX-aabbMinX, aabbMaxY-aabbMinY))
+				shapes = append(shapes, entityShape{style: entityDisplayMaterialAreaCheckerStyle, path: canvas.Rectangle(aabbMaxX-aabbMinX, aabbMaxY-aabbMinY).Translate(aabbMinX, aabbMinY)})
 			}
 
 		case "TeleportComponent":
@@ -160,8 +204,7 @@ func (e Entity) Draw(c *canvas.Context) {
 				}
 			}
 			if aabbMinX < aabbMaxX && aabbMinY < aabbMaxY {
-				c.Style = entityDisplayTeleportStyle
-				c.DrawPath(x+aabbMinX, y+aabbMinY, canvas.Rectangle(aabbMaxX-aabbMinX, aabbMaxY-aabbMinY))
+				shapes = append(shapes, entityShape{style: entityDisplayTeleportStyle, path: canvas.Rectangle(aabbMaxX-aabbMinX, aabbMaxY-aabbMinY).Translate(aabbMinX, aabbMinY)})
 			}
 
 		case "HitboxComponent": // General hit box component.
@@ -179,42 +222,97 @@ func (e Entity) Draw(c *canvas.Context) {
 				aabbMaxY, _ = member.(float64)
 			}
 			if aabbMinX < aabbMaxX && aabbMinY < aabbMaxY {
-				c.Style = entityDisplayHitBoxStyle
-				c.DrawPath(x+aabbMinX, y+aabbMinY, canvas.Rectangle(aabbMaxX-aabbMinX, aabbMaxY-aabbMinY))
+				shapes = append(shapes, entityShape{style: entityDisplayHitBoxStyle, path: canvas.Rectangle(aabbMaxX-aabbMinX, aabbMaxY-aabbMinY).Translate(aabbMinX, aabbMinY)})
 			}
 
 		case "CollisionTriggerComponent": // Checks if another entity is inside the given radius and box with the given width and height.
-			var width, height float64
-			path := &canvas.Path{}
+			var width, height, radius float64
 			if member, ok := component.Members["width"]; ok {
 				width, _ = member.(float64)
 			}
 			if member, ok := component.Members["height"]; ok {
 				height, _ = member.(float64)
 			}
-			if width > 0 && height > 0 {
+			if member, ok := component.Members["radius"]; ok {
+				radius, _ = member.(float64)
+			}
+			// The trigger area is the intersection of the box and the circle, if both are given.
+			// Both are centered on the entity.
+			path := &canvas.Path{}
+			switch {
+			case width > 0 && height > 0 && radius > 0:
+				path = RectangleCircleIntersection(-width/2, -height/2, width/2, height/2, 0, 0, radius)
+			case width > 0 && height > 0:
 				path = canvas.Rectangle(width, height).Translate(-width/2, -height/2)
+			case radius > 0:
+				path = canvas.Circle(radius)
 			}
-			// Theoretically we need to clip the area to the intersection of the box and the circle, but meh.
-			// TODO: Clip the area to the intersection of the box and the circle
-			//if member, ok := component.Members["radius"]; ok {
-			//	if radius, ok := member.(float64); ok && radius > 0 {
-			//		path = path.Append(canvas.Circle(radius))
-			//		path.And()
-			//	}
-			//}
 			if !path.Empty() {
-				c.Style = entityDisplayCollisionTriggerStyle
-				c.DrawPath(x, y, path)
+				shapes = append(shapes, entityShape{style: entityDisplayCollisionTriggerStyle, path: path})
 			}
 
 		}
 	}
 
-	c.SetFillColor(color.RGBA{255, 255, 255, 128})
-	c.SetStrokeColor(color.RGBA{255, 0, 0, 255})
-	c.DrawPath(x, y, canvas.Circle(3))
+	return shapes
+}
+
+// Bounds returns the bounding box of the entity in world coordinates.
+// This contains the entity origin and all component shapes of the entity and its children.
+func (e Entity) Bounds() image.Rectangle {
+	x, y := float64(e.Transform.X), float64(e.Transform.Y)
+	m := canvas.Identity.Translate(x, y).Mul(e.Transform.LocalMatrix())
+
+	bounds := image.Rect(int(math.Floor(x)), int(math.Floor(y)), int(math.Floor(x))+1, int(math.Floor(y))+1)
+	for _, shape := range e.shapes() {
+		shapeBounds := shape.path.Bounds().Transform(m)
+		bounds = bounds.Union(image.Rect(int(math.Floor(shapeBounds.X)), int(math.Floor(shapeBounds.Y)), int(math.Ceil(shapeBounds.X+shapeBounds.W)), int(math.Ceil(shapeBounds.Y+shapeBounds.H))))
+	}
+	for _, child := range e.Children {
+		bounds = bounds.Union(child.Bounds())
+	}
+
+	return bounds
+}
+
+// Draw draws the entity's components and all of its children onto the given context.
+// The context is expected to be in output coordinates, which are world coordinates divided by scale.Divider.
+//
+// The mod exports the transforms of child entities in world coordinates, as returned by `EntityGetTransform`.
+// So every entity in the hierarchy is drawn with its own transform, which already contains the transforms of all its parents.
+func (e Entity) Draw(c *canvas.Context, scale stitch.OverlayScale) {
+	factor := 1 / float64(scale.Divider)
+	x, y := float64(e.Transform.X)*factor, float64(e.Transform.Y)*factor
+	m := canvas.Identity.Scale(factor, factor).Mul(e.Transform.LocalMatrix())
+	lineWidth := entityDisplayLineWidth.OutputPixels(scale)
+	markerRadius := entityDisplayMarkerRadius.OutputPixels(scale)
+
+	// When zoomed out far enough, only draw the marker.
+	if scale.ZoomOut() >= entityDisplaySimplifyZoomOut {
+		drawEntityMarker(c, x, y, markerRadius, lineWidth)
+		return
+	}
+
+	for _, shape := range e.shapes() {
+		c.Style = shape.style
+		c.Style.StrokeWidth = lineWidth
+		c.DrawPath(x, y, shape.path.Transform(m))
+	}
+
+	for _, child := range e.Children {
+		child.Draw(c, scale)
+	}
+
+	drawEntityMarker(c, x, y, markerRadius, lineWidth)
 
 	//text := canvas.NewTextLine(entityDisplayFontFace, fmt.Sprintf("%s\n%s", e.Name, e.Filename), canvas.Left)
 	//c.DrawText(x, y, text)
 }
+
+// drawEntityMarker draws the marker that represents an entity's origin at the given output position.
+func drawEntityMarker(c *canvas.Context, x, y, radius, lineWidth float64) {
+	c.SetFillColor(color.RGBA{255, 255, 255, 128})
+	c.SetStrokeColor(color.RGBA{255, 0, 0, 255})
+	c.SetStrokeWidth(lineWidth)
+	c.DrawPath(x, y, canvas.Circle(radius))
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"path/filepath"
+	"strings"
+
+	"github.com/Dadido3/noita-mapcap/pkg/stitch"
+	"github.com/cheggaaa/pb/v3"
+)
+
+// OverlayLayer is an overlay with a name, so it can be exported into its own file.
+type OverlayLayer struct {
+	Name    string // Short name that is used in file names, like `entities`.
+	Overlay stitch.StitchedImageOverlay
+}
+
+// OverlayLayers is a list of overlay layers, from bottom to top.
+type OverlayLayers []OverlayLayer
+
+// Overlays returns the overlays of all layers.
+func (l OverlayLayers) Overlays() []stitch.StitchedImageOverlay {
+	result := make([]stitch.StitchedImageOverlay, 0, len(l))
+	for _, layer := range l {
+		result = append(result, layer.Overlay)
+	}
+	return result
+}
+
+// layerOutputPath returns the path of the layer with the given name, which is placed next to the output at outputPath.
+//
+// The layer name is inserted before the file extension, so `output.png` becomes `output.entities.png`.
+func layerOutputPath(outputPath, layerName string) string {
+	extension := filepath.Ext(outputPath)
+	return strings.TrimSuffix(outputPath, extension) + "." + layerName + extension
+}
+
+// exportLayers writes every layer as transparent image next to the output at outputPath.
+// The layers use the same format, size and position as the output, so they line up with it.
+//
+// The layers don't contain any tiles, so they are rendered from their overlays alone.
+// This way the tiles only need to be read once for the output itself.
+func exportLayers(ctx context.Context, layers OverlayLayers, outputRect image.Rectangle, scaleDivider int, outputPath string, dziTileSize, dziOverlap, webPLevel int) error {
+	for _, layer := range layers {
+		stitchedImage, err := stitch.NewStitchedImage(ctx, nil, outputRect, stitch.BlendMethodTransparent{}, 128, []stitch.StitchedImageOverlay{layer.Overlay}, scaleDivider)
+		if err != nil {
+			return fmt.Errorf("failed to create layer %q: %w", layer.Name, err)
+		}
+
+		err = exportStitchedImage(ctx, stitchedImage, layerOutputPath(outputPath, layer.Name), pb.Full.New(0), dziTileSize, dziOverlap, webPLevel, nil, nil)
+		stitchedImage.Close()
+		if err != nil {
+			return fmt.Errorf("failed to export layer %q: %w", layer.Name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Dadido3/noita-mapcap/pkg/stitch"
+	"github.com/cheggaaa/pb/v3"
+)
+
+// shardDirPath returns the directory that image shards of the output at outputPath are written into.
+//
+// `output.png` becomes `output_shards`, similar to the tile directory of DZI files.
+func shardDirPath(outputPath string) string {
+	extension := filepath.Ext(outputPath)
+	return strings.TrimSuffix(outputPath, extension) + "_shards"
+}
+
+// exportShard exports the part of the output at outputPath that belongs to the given shard.
+//
+// DZI shards write the tiles of the highest zoom level into the DZI tile directory.
+// Any other format is written as PNG chunks into the shard directory, see shardDirPath.
+func exportShard(ctx context.Context, stitchedImage *stitch.StitchedImage, outputPath string, shard stitch.Shard, bar *pb.ProgressBar, dziTileSize, dziOverlap, webPLevel int) error {
+	progress := barProgress(bar)
+	if bar != nil {
+		defer bar.Finish()
+	}
+
+	if strings.ToLower(filepath.Ext(outputPath)) == ".dzi" {
+		return stitch.ExportDZIShard(ctx, stitchedImage, outputPath, shard, dziTileSize, dziOverlap, webPLevel, progress)
+	}
+
+	if err := stitch.ExportImageShard(ctx, stitchedImage, shardDirPath(outputPath), shard, progress); err != nil {
+		return fmt.Errorf("export of image shard failed: %w", err)
+	}
+
+	return nil
+}
+
+// mergeShards assembles the output at outputPath from the shardCount shards that were exported with exportShard.
+//
+// annotations and layerNames are only used by DZI exports, to add clickable markers and layer toggles to the viewer.
+func mergeShards(ctx context.Context, stitchedImage *stitch.StitchedImage, outputPath string, shardCount int, bar *pb.ProgressBar, dziTileSize, dziOverlap, webPLevel int, annotations Annotations, layerNames []string) error {
+	if strings.ToLower(filepath.Ext(outputPath)) == ".dzi" {
+		progress := barProgress(bar)
+		if bar != nil {
+			defer bar.Finish()
+		}
+
+		if err := stitch.MergeDZIShards(ctx, stitchedImage, outputPath, shardCount, dziTileSize, dziOverlap, webPLevel, progress); err != nil {
+			return err
+		}
+
+		// Export viewer with annotation markers and layer toggles.
+		if len(annotations) > 0 || len(layerNames) > 0 {
+			if err := exportDZIViewer(outputPath, stitch.NewDZI(stitchedImage, dziTileSize, dziOverlap), annotations, layerNames); err != nil {
+				return fmt.Errorf("failed to export DZI viewer: %w", err)
+			}
+		}
+
+		return nil
+	}
+
+	shardDir := shardDirPath(outputPath)
+	mergedImage, err := stitch.MergeImageShards(ctx, stitchedImage, shardDir, shardCount)
+	if err != nil {
+		return err
+	}
+
+	err = exportStitchedImage(ctx, mergedImage, outputPath, bar, dziTileSize, dziOverlap, webPLevel, annotations, layerNames)
+	mergedImage.Close()
+	if err != nil {
+		return err
+	}
+
+	// The output is complete, so the shards are not needed anymore.
+	if err := os.RemoveAll(shardDir); err != nil {
+		log.Printf("Failed to remove shard directory %q: %v", shardDir, err)
+	}
+
+	return nil
+}
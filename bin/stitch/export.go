@@ -0,0 +1,73 @@
+// Copyright (c) 2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Dadido3/noita-mapcap/pkg/stitch"
+	"github.com/cheggaaa/pb/v3"
+)
+
+// barProgress returns a progress function that updates the given progress bar.
+// The bar is started with the first update.
+func barProgress(bar *pb.ProgressBar) stitch.ProgressFunc {
+	if bar == nil {
+		return nil
+	}
+
+	return func(current, total int64) {
+		if !bar.IsStarted() {
+			bar.SetRefreshRate(250 * time.Millisecond).Start()
+		}
+		bar.SetTotal(total).SetCurrent(current)
+	}
+}
+
+// exportStitchedImage exports the stitched image into outputPath, the format is determined by the file extension.
+//
+// annotations and layerNames are only used by DZI exports, to add clickable markers and layer toggles to the viewer.
+func exportStitchedImage(ctx context.Context, stitchedImage *stitch.StitchedImage, outputPath string, bar *pb.ProgressBar, dziTileSize, dziOverlap, webPLevel int, annotations Annotations, layerNames []string) error {
+	progress := barProgress(bar)
+	if bar != nil {
+		defer bar.Finish()
+	}
+
+	switch fileExtension := strings.ToLower(filepath.Ext(outputPath)); fileExtension {
+	case ".png":
+		if err := stitch.ExportPNG(ctx, stitchedImage, outputPath, progress); err != nil {
+			return fmt.Errorf("export of PNG file failed: %w", err)
+		}
+	case ".jpg", ".jpeg":
+		if err := stitch.ExportJPEG(ctx, stitchedImage, outputPath, progress); err != nil {
+			return fmt.Errorf("export of JPEG file failed: %w", err)
+		}
+	case ".webp":
+		if err := stitch.ExportWebP(ctx, stitchedImage, outputPath, webPLevel, progress); err != nil {
+			return fmt.Errorf("export of WebP file failed: %w", err)
+		}
+	case ".dzi":
+		if err := stitch.ExportDZI(ctx, stitchedImage, outputPath, dziTileSize, dziOverlap, webPLevel, progress); err != nil {
+			return fmt.Errorf("export of DZI file failed: %w", err)
+		}
+
+		// Export viewer with annotation markers and layer toggles.
+		// The layers are expected to be exported separately, see exportLayers.
+		if len(annotations) > 0 || len(layerNames) > 0 {
+			if err := exportDZIViewer(outputPath, stitch.NewDZI(stitchedImage, dziTileSize, dziOverlap), annotations, layerNames); err != nil {
+				return fmt.Errorf("failed to export DZI viewer: %w", err)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown output format %q", fileExtension)
+	}
+
+	return nil
+}
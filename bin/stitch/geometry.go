@@ -0,0 +1,90 @@
+// Copyright (c) 2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"math"
+
+	"github.com/tdewolff/canvas"
+)
+
+// circleMaxDeviation is the maximum distance in world pixels between a circle and its polygonal approximation.
+const circleMaxDeviation = 0.1
+
+// RectangleCircleIntersection returns the area that is inside the axis aligned rectangle (minX, minY)-(maxX, maxY) and inside the circle at (cx, cy) with the given radius.
+//
+// If one shape contains the other completely, the inner shape is returned as is.
+// Otherwise the circle is approximated by a polygon which is then clipped by the rectangle.
+// The result is an empty path if both shapes don't intersect.
+func RectangleCircleIntersection(minX, minY, maxX, maxY, cx, cy, radius float64) *canvas.Path {
+	// Check if the circle is inside the rectangle.
+	if cx-radius >= minX && cx+radius <= maxX && cy-radius >= minY && cy+radius <= maxY {
+		return canvas.Circle(radius).Translate(cx, cy)
+	}
+
+	// Check if the rectangle is inside the circle, by checking all its corners.
+	inCircle := func(x, y float64) bool { return (x-cx)*(x-cx)+(y-cy)*(y-cy) <= radius*radius }
+	if inCircle(minX, minY) && inCircle(maxX, minY) && inCircle(maxX, maxY) && inCircle(minX, maxY) {
+		return canvas.Rectangle(maxX-minX, maxY-minY).Translate(minX, minY)
+	}
+
+	// Approximate the circle with a polygon.
+	// The number of segments is chosen so that the polygon doesn't deviate from the circle by more than circleMaxDeviation.
+	segments := 16
+	if radius > circleMaxDeviation {
+		segments = max(segments, int(math.Ceil(math.Pi/math.Acos(1-circleMaxDeviation/radius))))
+	}
+	segments = min(segments, 4096)
+	polygon := make([]canvas.Point, 0, segments)
+	for i := 0; i < segments; i++ {
+		sin, cos := math.Sincos(2 * math.Pi * float64(i) / float64(segments))
+		polygon = append(polygon, canvas.Point{X: cx + cos*radius, Y: cy + sin*radius})
+	}
+
+	// Clip the polygon against every edge of the rectangle (Sutherland-Hodgman).
+	polygon = clipPolygon(polygon, func(p canvas.Point) float64 { return p.X - minX })
+	polygon = clipPolygon(polygon, func(p canvas.Point) float64 { return maxX - p.X })
+	polygon = clipPolygon(polygon, func(p canvas.Point) float64 { return p.Y - minY })
+	polygon = clipPolygon(polygon, func(p canvas.Point) float64 { return maxY - p.Y })
+
+	path := &canvas.Path{}
+	if len(polygon) < 3 {
+		return path
+	}
+	path.MoveTo(polygon[0].X, polygon[0].Y)
+	for _, p := range polygon[1:] {
+		path.LineTo(p.X, p.Y)
+	}
+	path.Close()
+
+	return path
+}
+
+// clipPolygon clips the given polygon against a half-plane.
+// The half-plane is defined by dist, which returns the signed distance of a point to the clipping edge.
+// Points with a positive or zero distance are kept.
+func clipPolygon(polygon []canvas.Point, dist func(canvas.Point) float64) []canvas.Point {
+	if len(polygon) == 0 {
+		return polygon
+	}
+
+	result := make([]canvas.Point, 0, len(polygon)+4)
+	prev := polygon[len(polygon)-1]
+	prevDist := dist(prev)
+	for _, cur := range polygon {
+		curDist := dist(cur)
+		if (curDist >= 0) != (prevDist >= 0) {
+			// The edge crosses the clipping edge, add the intersection point.
+			result = append(result, prev.Interpolate(cur, prevDist/(prevDist-curDist)))
+		}
+		if curDist >= 0 {
+			result = append(result, cur)
+		}
+		prev, prevDist = cur, curDist
+	}
+
+	return result
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/tdewolff/canvas"
+)
+
+// polygonArea returns the area of the polygon with the given corners.
+func polygonArea(points []canvas.Point) float64 {
+	var area float64
+	for i, p := range points {
+		q := points[(i+1)%len(points)]
+		area += p.X*q.Y - q.X*p.Y
+	}
+	return math.Abs(area) / 2
+}
+
+func TestRectangleCircleIntersection(t *testing.T) {
+	tests := []struct {
+		name                   string
+		minX, minY, maxX, maxY float64
+		cx, cy, radius         float64
+		wantEmpty              bool
+		wantBounds             canvas.Rect
+		wantArea               float64 // Only checked if not zero.
+	}{
+		{name: "circle inside rectangle", minX: 0, minY: 0, maxX: 100, maxY: 100, cx: 50, cy: 50, radius: 10,
+			wantBounds: canvas.Rect{X: 40, Y: 40, W: 20, H: 20}},
+		{name: "circle touching rectangle from inside", minX: 0, minY: 0, maxX: 20, maxY: 20, cx: 10, cy: 10, radius: 10,
+			wantBounds: canvas.Rect{X: 0, Y: 0, W: 20, H: 20}},
+		{name: "rectangle inside circle", minX: -5, minY: -5, maxX: 5, maxY: 5, cx: 0, cy: 0, radius: 100,
+			wantBounds: canvas.Rect{X: -5, Y: -5, W: 10, H: 10}, wantArea: 100},
+		{name: "circle outside rectangle", minX: 0, minY: 0, maxX: 10, maxY: 10, cx: 100, cy: 100, radius: 10,
+			wantEmpty: true},
+		{name: "circle outside rectangle corner", minX: 0, minY: 0, maxX: 10, maxY: 10, cx: 18, cy: 18, radius: 10,
+			wantEmpty: true},
+		{name: "circle centered on rectangle corner", minX: 0, minY: 0, maxX: 100, maxY: 100, cx: 0, cy: 0, radius: 10,
+			wantBounds: canvas.Rect{X: 0, Y: 0, W: 10, H: 10}, wantArea: math.Pi * 100 / 4},
+		{name: "circle cut by rectangle sides", minX: -10, minY: -5, maxX: 10, maxY: 5, cx: 0, cy: 0, radius: 10,
+			wantBounds: canvas.Rect{X: -10, Y: -5, W: 20, H: 10}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := RectangleCircleIntersection(tt.minX, tt.minY, tt.maxX, tt.maxY, tt.cx, tt.cy, tt.radius)
+
+			if tt.wantEmpty {
+				if !path.Empty() {
+					t.Fatalf("Expected empty path, got %v", path)
+				}
+				return
+			}
+			if path.Empty() {
+				t.Fatalf("Expected non empty path")
+			}
+
+			// The polygonal approximation of the circle lies inside the circle, so allow for circleMaxDeviation.
+			bounds := path.Bounds()
+			const eps = circleMaxDeviation + 1e-9
+			if math.Abs(bounds.X-tt.wantBounds.X) > eps || math.Abs(bounds.Y-tt.wantBounds.Y) > eps ||
+				math.Abs(bounds.W-tt.wantBounds.W) > 2*eps || math.Abs(bounds.H-tt.wantBounds.H) > 2*eps {
+				t.Errorf("Got bounds %v, want %v", bounds, tt.wantBounds)
+			}
+
+			// The approximation can't lose more area than the circumference times the allowed deviation.
+			if tt.wantArea != 0 {
+				if area := polygonArea(path.Coords()); area > tt.wantArea+1e-9 || area < tt.wantArea-2*math.Pi*tt.radius*circleMaxDeviation {
+					t.Errorf("Got area %v, want %v", area, tt.wantArea)
+				}
+			}
+		})
+	}
+}
+
+func TestClipPolygon(t *testing.T) {
+	square := []canvas.Point{{X: 0, Y: 0}, {X: 10, Y: 0}, {X: 10, Y: 10}, {X: 0, Y: 10}}
+
+	tests := []struct {
+		name     string
+		dist     func(canvas.Point) float64
+		wantArea float64
+		wantLen  int
+	}{
+		{name: "keep all", dist: func(p canvas.Point) float64 { return p.X + 1 }, wantArea: 100, wantLen: 4},
+		{name: "keep none", dist: func(p canvas.Point) float64 { return -p.X - 1 }, wantArea: 0, wantLen: 0},
+		{name: "keep left half", dist: func(p canvas.Point) float64 { return 5 - p.X }, wantArea: 50, wantLen: 4},
+		{name: "keep corner triangle", dist: func(p canvas.Point) float64 { return 5 - p.X - p.Y }, wantArea: 12.5, wantLen: 3},
+		{name: "edge on clipping line", dist: func(p canvas.Point) float64 { return p.X }, wantArea: 100, wantLen: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := clipPolygon(square, tt.dist)
+			if len(result) != tt.wantLen {
+				t.Fatalf("Got %d points %v, want %d", len(result), result, tt.wantLen)
+			}
+			if len(result) > 0 {
+				if area := polygonArea(result); math.Abs(area-tt.wantArea) > 1e-9 {
+					t.Errorf("Got area %v, want %v", area, tt.wantArea)
+				}
+			}
+		})
+	}
+
+	if result := clipPolygon(nil, func(p canvas.Point) float64 { return 0 }); len(result) != 0 {
+		t.Errorf("Clipping an empty polygon returned %v", result)
+	}
+}
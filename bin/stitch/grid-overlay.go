@@ -0,0 +1,172 @@
+// Copyright (c) 2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+
+	"github.com/Dadido3/noita-mapcap/pkg/stitch"
+	"github.com/tdewolff/canvas"
+)
+
+// gridDisplayLineWidth is the stroke width of grid lines and region outlines.
+var gridDisplayLineWidth = stitch.OverlaySize{Value: 1.0}
+
+// gridDisplayFontSize is the font size of grid and region labels in output pixels.
+var gridDisplayFontSize = 14.0
+
+// gridMinLabelSpacing is the minimum distance between grid lines in font sizes.
+// If grid lines are closer together, only every second, fourth, ... line is drawn.
+const gridMinLabelSpacing = 6.0
+
+var (
+	gridLineColor    = color.RGBA{255, 255, 255, 96}
+	gridRegionColor  = color.RGBA{255, 200, 0, 255}
+	gridTextColor    = color.RGBA{255, 255, 255, 255}
+	gridOutlineColor = color.RGBA{0, 0, 0, 255}
+)
+
+// GridOverlay draws a world coordinate grid with labels at the edges of the output, and optionally the outlines of regions.
+type GridOverlay struct {
+	worldBounds image.Rectangle // The output rectangle in world coordinates. Labels are placed at its edges.
+	cellSize    int             // Distance between grid lines in world pixels. 0 disables the grid.
+	offset      image.Point     // World coordinates of a point where grid lines cross.
+	labels      bool
+	regions     Regions
+}
+
+// NewGridOverlay returns an overlay that draws a grid with the given cell size in world pixels.
+//
+// worldBounds is the rectangle of the whole output in world coordinates, labels are placed along its top and left edge.
+// The grid lines go through offset, which is given in world coordinates.
+// If cellSize is 0, only the given regions are drawn.
+func NewGridOverlay(worldBounds image.Rectangle, cellSize int, offset image.Point, labels bool, regions Regions) (*GridOverlay, error) {
+	if cellSize < 0 {
+		return nil, fmt.Errorf("invalid grid size of %d", cellSize)
+	}
+
+	return &GridOverlay{
+		worldBounds: worldBounds,
+		cellSize:    cellSize,
+		offset:      offset,
+		labels:      labels,
+		regions:     regions,
+	}, nil
+}
+
+// gridLines returns the world coordinates of all grid lines between min and max along one axis.
+// Lines are thinned out so that they are at least minSpacing output pixels apart.
+func gridLines(min, max, cellSize, offset, divider int, minSpacing float64) []int {
+	step := cellSize
+	for float64(step)/float64(divider) < minSpacing {
+		step *= 2
+	}
+
+	var result []int
+	for i := int(math.Ceil(float64(min-offset) / float64(step))); offset+i*step <= max; i++ {
+		result = append(result, offset+i*step)
+	}
+
+	return result
+}
+
+// Draw implements the StitchedImageOverlay interface.
+func (g *GridOverlay) Draw(destImage *image.RGBA, scale stitch.OverlayScale) {
+	destRect := destImage.Bounds()
+	divider := scale.Divider
+	factor := 1 / float64(divider)
+
+	// The rectangle of the whole output at this scale.
+	outputBounds := image.Rect(stitch.DivideFloor(g.worldBounds.Min.X, divider), stitch.DivideFloor(g.worldBounds.Min.Y, divider), stitch.DivideCeil(g.worldBounds.Max.X, divider), stitch.DivideCeil(g.worldBounds.Max.Y, divider))
+
+	// The rectangle of destImage in world coordinates.
+	// Extended by the size of labels, so that labels that start outside of destImage are drawn too.
+	margin := int(gridDisplayFontSize*gridMinLabelSpacing*float64(divider)) + divider
+	worldRect := image.Rectangle{destRect.Min.Mul(divider), destRect.Max.Mul(divider)}.Inset(-margin)
+
+	lineWidth := gridDisplayLineWidth.OutputPixels(scale)
+
+	var xLines, yLines []int
+	if g.cellSize > 0 {
+		xLines = gridLines(worldRect.Min.X, worldRect.Max.X, g.cellSize, g.offset.X, divider, gridDisplayFontSize*gridMinLabelSpacing)
+		yLines = gridLines(worldRect.Min.Y, worldRect.Max.Y, g.cellSize, g.offset.Y, divider, gridDisplayFontSize*gridMinLabelSpacing)
+	}
+
+	c, ctx := newOverlayCanvas(destImage)
+	ctx.SetFillColor(canvas.Transparent)
+	ctx.SetStrokeWidth(lineWidth)
+
+	// Grid lines.
+	ctx.SetStrokeColor(gridLineColor)
+	for _, x := range xLines {
+		ctx.DrawPath(0, 0, canvas.Line(0, float64(destRect.Dy()+2)).Translate(float64(x)*factor, float64(destRect.Min.Y-1)))
+	}
+	for _, y := range yLines {
+		ctx.DrawPath(0, 0, canvas.Line(float64(destRect.Dx()+2), 0).Translate(float64(destRect.Min.X-1), float64(y)*factor))
+	}
+
+	// Region outlines.
+	ctx.SetStrokeColor(gridRegionColor)
+	ctx.SetStrokeWidth(lineWidth * 2)
+	for _, region := range g.regions {
+		if !region.Rect().Overlaps(worldRect) {
+			continue
+		}
+		rect := region.Rect()
+		path := canvas.Rectangle(float64(rect.Dx())*factor, float64(rect.Dy())*factor).Translate(float64(rect.Min.X)*factor, float64(rect.Min.Y)*factor)
+		ctx.DrawPath(0, 0, path)
+	}
+
+	renderOverlayCanvas(c, destImage)
+
+	if !g.labels && len(g.regions) == 0 {
+		return
+	}
+
+	face := newOverlayFontFace(gridDisplayFontSize)
+	defer face.Close()
+
+	// Labels along the top and left edge of the output.
+	textMargin := 2 + lineWidth
+	_, lineHeight := measureOverlayText(face, "0")
+	if g.labels {
+		for _, x := range xLines {
+			drawOverlayText(destImage, face, strconv.Itoa(x), float64(x)*factor+textMargin, float64(outputBounds.Min.Y)+textMargin, 0, 0, gridTextColor, gridOutlineColor)
+		}
+		for _, y := range yLines {
+			// Skip labels that would overlap with the labels along the top edge.
+			if float64(y)*factor < float64(outputBounds.Min.Y)+lineHeight+textMargin {
+				continue
+			}
+			drawOverlayText(destImage, face, strconv.Itoa(y), float64(outputBounds.Min.X)+textMargin, float64(y)*factor+textMargin, 0, 0, gridTextColor, gridOutlineColor)
+		}
+	}
+
+	// Region names in the top left corner of every region, or of the part of it that is inside the output.
+	// Moved down by one line, so they don't cover the grid labels.
+	for _, region := range g.regions {
+		rect := region.Rect()
+		x := math.Max(float64(rect.Min.X)*factor, float64(outputBounds.Min.X)) + textMargin + lineWidth
+		y := math.Max(float64(rect.Min.Y)*factor, float64(outputBounds.Min.Y)) + textMargin + lineWidth + lineHeight
+		drawOverlayText(destImage, face, region.Name, x, y, 0, 0, gridRegionColor, gridOutlineColor)
+	}
+}
+
+// LegendEntries implements the LegendProvider interface.
+func (g *GridOverlay) LegendEntries() []LegendEntry {
+	var entries []LegendEntry
+	if g.cellSize > 0 {
+		entries = append(entries, LegendEntry{Label: fmt.Sprintf("Grid (%d world px)", g.cellSize), Symbol: legendLine(gridLineColor)})
+	}
+	if len(g.regions) > 0 {
+		entries = append(entries, LegendEntry{Label: "Region", Symbol: legendSwatch(canvas.Style{Stroke: canvas.Paint{Color: gridRegionColor}})})
+	}
+	return entries
+}
@@ -0,0 +1,231 @@
+// Copyright (c) 2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+
+	"github.com/Dadido3/noita-mapcap/pkg/stitch"
+)
+
+// ColorRamp is a list of colors that are evenly spaced between 0 and 1.
+type ColorRamp []color.NRGBA
+
+// DefaultHeatMapColorRamp is a color ramp that goes from transparent over blue, cyan, green and yellow to red.
+const DefaultHeatMapColorRamp = "#0000ff00,#0000ff80,#00ffffa0,#00ff00c0,#ffff00d0,#ff0000e0"
+
+// parseHexColor parses a color in the form `#rrggbb` or `#rrggbbaa`.
+func parseHexColor(s string) (color.NRGBA, error) {
+	hex := strings.TrimPrefix(s, "#")
+
+	var col color.NRGBA
+	switch len(hex) {
+	case 6:
+		col.A = 255
+		if _, err := fmt.Sscanf(hex, "%02x%02x%02x", &col.R, &col.G, &col.B); err != nil {
+			return col, fmt.Errorf("invalid color %q: %w", s, err)
+		}
+	case 8:
+		if _, err := fmt.Sscanf(hex, "%02x%02x%02x%02x", &col.R, &col.G, &col.B, &col.A); err != nil {
+			return col, fmt.Errorf("invalid color %q: %w", s, err)
+		}
+	default:
+		return col, fmt.Errorf("invalid color %q", s)
+	}
+
+	return col, nil
+}
+
+// ParseColorRamp parses a comma separated list of colors in the form `#rrggbb` or `#rrggbbaa`.
+func ParseColorRamp(list string) (ColorRamp, error) {
+	var result ColorRamp
+	for _, entry := range splitList(list) {
+		col, err := parseHexColor(entry)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, col)
+	}
+
+	if len(result) == 0 {
+		return nil, fmt.Errorf("color ramp is empty")
+	}
+
+	return result, nil
+}
+
+// At returns the linearly interpolated color at t, which is clamped to the range [0, 1].
+func (r ColorRamp) At(t float64) color.NRGBA {
+	if len(r) == 1 || t <= 0 {
+		return r[0]
+	}
+	if t >= 1 {
+		return r[len(r)-1]
+	}
+
+	pos := t * float64(len(r)-1)
+	i := int(pos)
+	frac := pos - float64(i)
+	a, b := r[i], r[i+1]
+	lerp := func(a, b uint8) uint8 { return uint8(math.Round(float64(a) + (float64(b)-float64(a))*frac)) }
+
+	return color.NRGBA{lerp(a.R, b.R), lerp(a.G, b.G), lerp(a.B, b.B), lerp(a.A, b.A)}
+}
+
+// HeatMapOverlay draws a smoothed density map of entities over the stitched image.
+//
+// Every entity contributes a kernel with the given radius to the density.
+// The density is then mapped to a color with the color ramp, where the maximum density corresponds to the end of the ramp.
+type HeatMapOverlay struct {
+	entities   Entities
+	index      *SpatialIndex
+	radius     float64 // Kernel radius in world pixels.
+	maxDensity float64 // Density that is mapped to the end of the color ramp.
+	colorRamp  ColorRamp
+}
+
+// NewHeatMapOverlay returns an overlay that draws a density heat map of the given entities.
+//
+// radius is the kernel radius in world pixels.
+// If maxDensity is 0 or less, the highest density found at any entity position is used.
+// This way the heat map has the same scale in every part of the output, regardless of which part is drawn.
+func NewHeatMapOverlay(entities Entities, radius, maxDensity float64, colorRamp ColorRamp) (*HeatMapOverlay, error) {
+	if radius <= 0 {
+		return nil, fmt.Errorf("invalid kernel radius of %v", radius)
+	}
+	if len(colorRamp) == 0 {
+		return nil, fmt.Errorf("color ramp is empty")
+	}
+
+	bounds := make([]image.Rectangle, 0, len(entities))
+	for _, entity := range entities {
+		x, y := int(math.Floor(float64(entity.Transform.X))), int(math.Floor(float64(entity.Transform.Y)))
+		bounds = append(bounds, image.Rect(x, y, x+1, y+1))
+	}
+
+	h := &HeatMapOverlay{
+		entities:   entities,
+		index:      NewSpatialIndex(bounds, max(int(radius), 64)),
+		radius:     radius,
+		maxDensity: maxDensity,
+		colorRamp:  colorRamp,
+	}
+
+	if h.maxDensity <= 0 {
+		h.maxDensity = h.highestDensity()
+	}
+
+	return h, nil
+}
+
+// heatMapKernel returns the weight of the kernel for the given squared distance and squared radius.
+// This is a quartic kernel, which is smooth and falls off to 0 at the radius.
+func heatMapKernel(distSqr, radiusSqr float64) float64 {
+	if distSqr >= radiusSqr {
+		return 0
+	}
+	w := 1 - distSqr/radiusSqr
+	return w * w
+}
+
+// highestDensity returns the highest density at any entity position.
+// The real maximum may be a bit higher somewhere between entities, but that is not noticeable.
+func (h *HeatMapOverlay) highestDensity() float64 {
+	radiusSqr := h.radius * h.radius
+	margin := int(math.Ceil(h.radius)) + 1
+
+	var result float64
+	for _, entity := range h.entities {
+		x, y := float64(entity.Transform.X), float64(entity.Transform.Y)
+		queryRect := image.Rect(int(math.Floor(x)), int(math.Floor(y)), int(math.Floor(x))+1, int(math.Floor(y))+1).Inset(-margin)
+
+		var density float64
+		for _, i := range h.index.Query(queryRect) {
+			other := h.entities[i]
+			dx, dy := float64(other.Transform.X)-x, float64(other.Transform.Y)-y
+			density += heatMapKernel(dx*dx+dy*dy, radiusSqr)
+		}
+		result = max(result, density)
+	}
+
+	return result
+}
+
+// Draw implements the StitchedImageOverlay interface.
+func (h *HeatMapOverlay) Draw(destImage *image.RGBA, scale stitch.OverlayScale) {
+	if h.maxDensity <= 0 {
+		return
+	}
+
+	destRect := destImage.Bounds()
+	divider := float64(scale.Divider)
+
+	// If the kernel is smaller than an output pixel, it would fall between the sampled pixel centers.
+	// So widen the kernel, and reduce its weight so that the amount every entity contributes stays the same.
+	radius := max(h.radius, divider)
+	weight := (h.radius * h.radius) / (radius * radius)
+	radiusSqr := radius * radius
+
+	margin := int(math.Ceil(radius)) + 1
+	worldRect := image.Rectangle{destRect.Min.Mul(scale.Divider), destRect.Max.Mul(scale.Divider)}.Inset(-margin)
+
+	density := make([]float64, destRect.Dx()*destRect.Dy())
+	for _, i := range h.index.Query(worldRect) {
+		entity := h.entities[i]
+		x, y := float64(entity.Transform.X), float64(entity.Transform.Y)
+
+		// Output pixels that may be affected by this entity.
+		affected := image.Rect(
+			int(math.Floor((x-radius)/divider)), int(math.Floor((y-radius)/divider)),
+			int(math.Ceil((x+radius)/divider))+1, int(math.Ceil((y+radius)/divider))+1,
+		).Intersect(destRect)
+
+		for iy := affected.Min.Y; iy < affected.Max.Y; iy++ {
+			dy := (float64(iy)+0.5)*divider - y
+			row := density[(iy-destRect.Min.Y)*destRect.Dx():]
+			for ix := affected.Min.X; ix < affected.Max.X; ix++ {
+				dx := (float64(ix)+0.5)*divider - x
+				row[ix-destRect.Min.X] += weight * heatMapKernel(dx*dx+dy*dy, radiusSqr)
+			}
+		}
+	}
+
+	for iy := destRect.Min.Y; iy < destRect.Max.Y; iy++ {
+		row := density[(iy-destRect.Min.Y)*destRect.Dx():]
+		for ix := destRect.Min.X; ix < destRect.Max.X; ix++ {
+			value := row[ix-destRect.Min.X]
+			if value <= 0 {
+				continue
+			}
+
+			col := h.colorRamp.At(value / h.maxDensity)
+			if col.A == 0 {
+				continue
+			}
+
+			// Blend the color over the existing pixel.
+			dst := destImage.RGBAAt(ix, iy)
+			a := uint32(col.A)
+			inv := 255 - a
+			destImage.SetRGBA(ix, iy, color.RGBA{
+				R: uint8((uint32(col.R)*a + uint32(dst.R)*inv + 127) / 255),
+				G: uint8((uint32(col.G)*a + uint32(dst.G)*inv + 127) / 255),
+				B: uint8((uint32(col.B)*a + uint32(dst.B)*inv + 127) / 255),
+				A: uint8((a*255 + uint32(dst.A)*inv + 127) / 255),
+			})
+		}
+	}
+}
+
+// LegendEntries implements the LegendProvider interface.
+func (h *HeatMapOverlay) LegendEntries() []LegendEntry {
+	return []LegendEntry{{Label: "Entity density (low to high)", Symbol: legendLine(legendRamp(h.colorRamp)...)}}
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// JSONRecordsError is returned when a list of JSON records could only be decoded partially.
+type JSONRecordsError struct {
+	Records int   // The number of records that were decoded successfully.
+	Offset  int64 // The byte offset of the end of the last successfully decoded record.
+	Dropped int64 // The number of bytes after Offset that were dropped.
+	Err     error // The error that stopped the decoding.
+}
+
+func (e *JSONRecordsError) Error() string {
+	return fmt.Sprintf("recovered %d records, dropped %d bytes after byte offset %d: %v", e.Records, e.Dropped, e.Offset, e.Err)
+}
+
+func (e *JSONRecordsError) Unwrap() error {
+	return e.Err
+}
+
+// errJSONTrailingData is used when there is data after the closing bracket of a JSON array.
+var errJSONTrailingData = errors.New("unexpected data after JSON array")
+
+// DecodeJSONRecords decodes a list of records from the given reader.
+//
+// The input can either be a JSON array of records, or a stream of records like in the JSON Lines format.
+// The records are decoded one by one, so the input doesn't have to be held in memory.
+//
+// If the input is truncated or corrupted, all records before the damaged part are returned along with a *JSONRecordsError.
+// A JSON array with missing closing bracket or with a trailing comma is not considered damaged, as long as no record is cut off.
+func DecodeJSONRecords[T any](r io.Reader) ([]T, error) {
+	bufReader := bufio.NewReader(r)
+
+	// Determine the format by looking at the first non whitespace character.
+	isArray := false
+	var skipped int64
+	for {
+		b, err := bufReader.ReadByte()
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if b == ' ' || b == '\t' || b == '\n' || b == '\r' {
+			skipped++
+			continue
+		}
+		isArray = b == '['
+		if err := bufReader.UnreadByte(); err != nil {
+			return nil, err
+		}
+		break
+	}
+
+	dec := json.NewDecoder(bufReader)
+	var result []T
+	arrayOpen := false
+
+	// handleErr wraps the given error into a JSONRecordsError, and determines how many bytes were dropped.
+	handleErr := func(decErr error) error {
+		offset := skipped + dec.InputOffset()
+		// Inside an unclosed array, a trailing comma is not lost data, as records are appended by rewriting the closing bracket.
+		dropped, significant, err := countJSONRemainder(io.MultiReader(dec.Buffered(), bufReader), arrayOpen)
+		if err != nil {
+			return err
+		}
+		if significant == 0 {
+			// The input just ended after a complete record, nothing was lost.
+			// This doesn't depend on the kind of decErr, as the decoder reports a truncated input differently depending on where it ends.
+			return nil
+		}
+
+		return &JSONRecordsError{
+			Records: len(result),
+			Offset:  offset,
+			Dropped: dropped,
+			Err:     decErr,
+		}
+	}
+
+	if isArray {
+		// Consume the opening bracket.
+		if _, err := dec.Token(); err != nil {
+			return nil, handleErr(err)
+		}
+		arrayOpen = true
+	}
+
+	for {
+		if isArray && !dec.More() {
+			break
+		}
+
+		var record T
+		if err := dec.Decode(&record); err != nil {
+			if !isArray && err == io.EOF {
+				// Regular end of a stream of records.
+				return result, nil
+			}
+			return result, handleErr(err)
+		}
+		result = append(result, record)
+	}
+
+	// Consume the closing bracket.
+	if _, err := dec.Token(); err != nil {
+		return result, handleErr(err)
+	}
+	arrayOpen = false
+
+	// Anything but whitespace after the array would be lost silently otherwise.
+	if err := handleErr(errJSONTrailingData); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// countJSONRemainder reads r until its end, and returns the number of bytes, and the number of bytes that are neither whitespace nor, if skipCommas is set, commas.
+func countJSONRemainder(r io.Reader, skipCommas bool) (total, significant int64, err error) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		for _, b := range buf[:n] {
+			if b != ' ' && b != '\t' && b != '\n' && b != '\r' && (!skipCommas || b != ',') {
+				significant++
+			}
+		}
+		total += int64(n)
+		if err == io.EOF {
+			return total, significant, nil
+		}
+		if err != nil {
+			return total, significant, err
+		}
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDecodeJSONRecords(t *testing.T) {
+	type record struct {
+		A int `json:"a"`
+	}
+
+	tests := []struct {
+		name        string
+		input       string
+		wantRecords []int
+		wantErr     *JSONRecordsError // Only Records, Offset and Dropped are compared.
+	}{
+		{name: "empty", input: "", wantRecords: nil},
+		{name: "whitespace only", input: " \n\t", wantRecords: nil},
+		{name: "array", input: `[{"a":1},{"a":2}]`, wantRecords: []int{1, 2}},
+		{name: "array with whitespace", input: " \n[ {\"a\":1} ,\n{\"a\":2} ]\n", wantRecords: []int{1, 2}},
+		{name: "empty array", input: `[]`, wantRecords: nil},
+		{name: "JSON Lines", input: "{\"a\":1}\n{\"a\":2}\n", wantRecords: []int{1, 2}},
+		{name: "JSON Lines without trailing newline", input: "{\"a\":1}\n{\"a\":2}", wantRecords: []int{1, 2}},
+
+		{name: "array missing closing bracket", input: "[{\"a\":1},{\"a\":2}\n", wantRecords: []int{1, 2}},
+		{name: "array missing closing bracket after comma", input: `[{"a":1},{"a":2},`, wantRecords: []int{1, 2}},
+		{name: "array cut while appending", input: "[\n\t{\"a\":1},\n\t", wantRecords: []int{1}},
+		{name: "array cut mid-record", input: `[{"a":1},{"a":2},{"a":3`, wantRecords: []int{1, 2},
+			wantErr: &JSONRecordsError{Records: 2, Offset: 16, Dropped: 7}},
+		{name: "array cut mid-key", input: `[{"a":1},{"`, wantRecords: []int{1},
+			wantErr: &JSONRecordsError{Records: 1, Offset: 8, Dropped: 3}},
+		{name: "array with trailing garbage", input: "[{\"a\":1}]garbage\n", wantRecords: []int{1},
+			wantErr: &JSONRecordsError{Records: 1, Offset: 9, Dropped: 8}},
+		{name: "array with trailing comma", input: `[{"a":1}],`, wantRecords: []int{1},
+			wantErr: &JSONRecordsError{Records: 1, Offset: 9, Dropped: 1}},
+		{name: "array with corrupted record", input: `[{"a":1},{"a":x},{"a":3}]`, wantRecords: []int{1},
+			wantErr: &JSONRecordsError{Records: 1, Offset: 8, Dropped: 17}},
+
+		{name: "JSON Lines cut mid-record", input: "{\"a\":1}\n{\"a\":2}\n{\"a\":", wantRecords: []int{1, 2},
+			wantErr: &JSONRecordsError{Records: 2, Offset: 15, Dropped: 6}},
+		{name: "JSON Lines with trailing garbage", input: "{\"a\":1}\n{\"a\":2}\ngarbage\n", wantRecords: []int{1, 2},
+			wantErr: &JSONRecordsError{Records: 2, Offset: 15, Dropped: 9}},
+		{name: "JSON Lines with corrupted record", input: "{\"a\":1}\n{\"a\":x}\n{\"a\":3}\n", wantRecords: []int{1},
+			wantErr: &JSONRecordsError{Records: 1, Offset: 7, Dropped: 17}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := DecodeJSONRecords[record](strings.NewReader(tt.input))
+
+			var got []int
+			for _, r := range records {
+				got = append(got, r.A)
+			}
+			if !slices.Equal(got, tt.wantRecords) {
+				t.Errorf("Got records %v, want %v", got, tt.wantRecords)
+			}
+
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				return
+			}
+
+			var recordsErr *JSONRecordsError
+			if !errors.As(err, &recordsErr) {
+				t.Fatalf("Expected *JSONRecordsError, got %v", err)
+			}
+			if recordsErr.Records != tt.wantErr.Records || recordsErr.Offset != tt.wantErr.Offset || recordsErr.Dropped != tt.wantErr.Dropped {
+				t.Errorf("Got records %d, offset %d, dropped %d, want records %d, offset %d, dropped %d",
+					recordsErr.Records, recordsErr.Offset, recordsErr.Dropped, tt.wantErr.Records, tt.wantErr.Offset, tt.wantErr.Dropped)
+			}
+			if recordsErr.Offset+recordsErr.Dropped != int64(len(tt.input)) {
+				t.Errorf("Offset %d and dropped %d don't add up to the input length %d", recordsErr.Offset, recordsErr.Dropped, len(tt.input))
+			}
+			if recordsErr.Err == nil {
+				t.Errorf("Expected a wrapped error")
+			}
+		})
+	}
+}
@@ -6,27 +6,73 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"image"
 	"log"
+	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/1lann/promptui"
+	"github.com/Dadido3/noita-mapcap/pkg/stitch"
 	"github.com/cheggaaa/pb/v3"
 )
 
 var flagInputPath = flag.String("input", filepath.Join(".", "..", "..", "output"), "The source path of the image tiles to be stitched.")
 var flagEntitiesInputPath = flag.String("entities", filepath.Join(".", "..", "..", "output", "entities.json"), "The path to the entities.json file.")
 var flagPlayerPathInputPath = flag.String("player-path", filepath.Join(".", "..", "..", "output", "player-path.json"), "The path to the player-path.json file.")
+var flagEntityDedupTolerance = flag.Float64("entity-dedup-tolerance", 8, "Entities with the same filename, name and tags that are not farther apart than this distance in world pixels are considered duplicates. A negative value disables the deduplication.")
+var flagEntityDedupKeep = flag.String("entity-dedup-keep", "newest", "Which entity of a group of duplicates is kept. Either `newest` or `first`.")
 var flagOutputPath = flag.String("output", filepath.Join(".", "output.png"), "The path and filename of the resulting stitched image. Supported formats/file extensions: `.png`, `.webp`, `.jpg`, `.dzi`.")
 var flagScaleDivider = flag.Int("divide", 1, "A downscaling factor. 2 will produce an image with half the side lengths.")
 var flagBlendTileLimit = flag.Int("blend-tile-limit", 9, "Limits median blending to the n newest tiles by file modification time. If set to 0, all available tiles will be median blended.")
 var flagDZITileSize = flag.Int("dzi-tile-size", 512, "The size of the resulting deep zoom image (DZI) tiles in pixels.")
 var flagDZIOverlap = flag.Int("dzi-tile-overlap", 2, "The number of additional pixels around every deep zoom image (DZI) tile.")
 var flagWebPLevel = flag.Int("webp-level", 8, "Compression level of WebP files, from 0 (fast) to 9 (slow, best compression).")
+var flagCacheBudget = flag.Int("cache-budget", 1024, "The amount of memory in MiB that is used to cache decoded tiles and stitched image parts.")
+var flagTileCache = flag.String("tile-cache", "", "A directory where decoded tiles are stored in a format that is much faster to read. Repeated runs over the same tiles will then skip decoding them. Disabled if empty.")
+var flagOverlaySizeUnit = flag.String("overlay-size-unit", "world", "The unit of all overlay line widths and marker sizes. Either `world` (world pixels, overlays shrink with the output) or `screen` (output pixels).")
+var flagPlayerPathWidth = flag.Float64("player-path-width", 3, "The line width of the player path overlay.")
+var flagPlayerPathSimplify = flag.Float64("player-path-simplify", 0, "Simplify the player path before drawing, so that it doesn't deviate more than this distance in world pixels from the original path. 0 disables simplification.")
+var flagPlayerPathColoring = flag.String("player-path-coloring", "hp", "How the player path is colored. Either `hp` (red to green by HP, purple when polymorphed), `time` (along the color ramp by elapsed time) or `index` (along the color ramp by segment index).")
+var flagPlayerPathColors = flag.String("player-path-colors", DefaultPlayerPathColorRamp, "Comma separated list of colors in the form `#rrggbb` or `#rrggbbaa` that are used to color the player path from start to end.")
+var flagPlayerPathMarkers = flag.Bool("player-path-markers", false, "Draw markers at the start and end of the player path, and where the player lost a lot of HP, got polymorphed or teleported.")
+var flagPlayerPathMarkerRadius = flag.Float64("player-path-marker-radius", 8, "The radius of the player path markers.")
+var flagPlayerPathHPDrop = flag.Float64("player-path-hp-drop", 0.25, "The fraction of the maximum HP that the player has to lose at once to be marked.")
+var flagPlayerPathTeleportDistance = flag.Float64("player-path-teleport-distance", 512, "The distance in world pixels the player has to travel at once to be marked as teleport.")
+var flagEntityLineWidth = flag.Float64("entity-line-width", 1, "The line width of entity component shapes.")
+var flagEntityMarkerRadius = flag.Float64("entity-marker-radius", 3, "The radius of the marker that is drawn at the position of every entity.")
+var flagHeatMap = flag.Bool("heatmap", false, "Draw a density heat map of the entities.")
+var flagHeatMapTags = flag.String("heatmap-tags", "", "Comma separated list of tags. Only entities with all of these tags are included in the heat map.")
+var flagHeatMapComponents = flag.String("heatmap-components", "", "Comma separated list of component types. Only entities with all of these components are included in the heat map.")
+var flagHeatMapRadius = flag.Float64("heatmap-radius", 256, "The radius of the heat map kernel in world pixels. Larger values result in a smoother heat map.")
+var flagHeatMapMax = flag.Float64("heatmap-max", 0, "The density that corresponds to the end of the heat map color ramp. If set to 0, the highest density in the heat map is used.")
+var flagHeatMapColors = flag.String("heatmap-colors", DefaultHeatMapColorRamp, "Comma separated list of colors in the form `#rrggbb` or `#rrggbbaa` that are used for the heat map, from low to high density.")
+var flagHeatMapLayer = flag.Bool("heatmap-layer", false, "Only export the heat map on a transparent background, without any tiles or other overlays.")
+var flagGrid = flag.Int("grid", 0, "Draw a grid with this cell size in world pixels, e.g. 512 for chunks or 35840 for parallel worlds. 0 disables the grid.")
+var flagGridOffsetX = flag.Int("grid-offset-x", 0, "The x world coordinate of a vertical grid line. Use 17920 together with a grid of 35840 to show the borders of parallel worlds.")
+var flagGridOffsetY = flag.Int("grid-offset-y", 0, "The y world coordinate of a horizontal grid line.")
+var flagGridLabels = flag.Bool("grid-labels", true, "Label the grid lines with their world coordinates along the top and left edge of the output.")
+var flagGridRegions = flag.String("grid-regions", "", "Outline the regions in this JSON file. Use `areas` for the capture areas listed in AREAS.md.")
+var flagGridLineWidth = flag.Float64("grid-line-width", 1, "The line width of grid lines. Region outlines are twice as wide.")
+var flagGridFontSize = flag.Float64("grid-font-size", 14, "The font size of grid and region labels in output pixels.")
+var flagAnnotations = flag.String("annotations", "", "The path to a JSON or GeoJSON file with annotations, like points, rectangles and lines with labels, that are drawn over the output.")
+var flagAnnotationLineWidth = flag.Float64("annotation-line-width", 2, "The line width of annotation lines and outlines.")
+var flagAnnotationIconRadius = flag.Float64("annotation-icon-radius", 6, "The radius of the icons of point annotations.")
+var flagAnnotationFontSize = flag.Float64("annotation-font-size", 14, "The font size of annotation labels in output pixels.")
+var flagLayers = flag.Bool("layers", false, "Write every overlay into its own transparent file next to the output, instead of drawing them into the output.")
+var flagTitle = flag.String("title", "", "Title text that is drawn into the map info block. Lines are separated by `\\n`, and the placeholders `{version}` and `{date}` are replaced.")
+var flagLegend = flag.Bool("legend", false, "Draw a legend of all active overlays into the map info block.")
+var flagScaleBar = flag.Bool("scale-bar", false, "Draw a scale bar into the map info block.")
+var flagInfoCorner = flag.String("info-corner", "bottom-left", "The corner of the output where the map info block is placed. One of `top-left`, `top-right`, `bottom-left` or `bottom-right`.")
+var flagInfoFontSize = flag.Float64("info-font-size", 14, "The font size of the map info block in output pixels.")
+var flagShard = flag.String("shard", "", "Only export one part of the output, given as `i/n` for the i-th of n parts. The parts can be exported by separate processes or machines, and are combined with -merge-shards afterwards.")
+var flagMergeShards = flag.Int("merge-shards", 0, "Assemble the output from this number of parts that were exported with -shard. All other arguments have to be the same as for the parts.")
 var flagXMin = flag.Int("xmin", 0, "Left bound of the output rectangle. This coordinate is included in the output.")
 var flagYMin = flag.Int("ymin", 0, "Upper bound of the output rectangle. This coordinate is included in the output.")
 var flagXMax = flag.Int("xmax", 0, "Right bound of the output rectangle. This coordinate is not included in the output.")
@@ -35,9 +81,72 @@ var flagYMax = flag.Int("ymax", 0, "Lower bound of the output rectangle. This co
 func main() {
 	log.Printf("Noita MapCapture stitching tool v%s.", version)
 
+	// Run a command, if there is one given.
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "entities":
+			if err := runEntitiesCommand(os.Args[2:]); err != nil {
+				log.Panicf("Failed to run entities command: %v.", err)
+			}
+			return
+		case "player-path-export":
+			if err := runPlayerPathExportCommand(os.Args[2:]); err != nil {
+				log.Panicf("Failed to run player path export command: %v.", err)
+			}
+			return
+		case "player-path":
+			if err := runPlayerPathCommand(os.Args[2:]); err != nil {
+				log.Panicf("Failed to run player path command: %v.", err)
+			}
+			return
+		}
+	}
+
 	flag.Parse()
 
-	var overlays []StitchedImageOverlay
+	// Interrupting the program cancels any running export, without leaving partially written files behind.
+	// A second interrupt will terminate the program immediately.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	context.AfterFunc(ctx, stop)
+
+	stitch.DefaultCache.SetBudget(int64(*flagCacheBudget) << 20)
+
+	if *flagTileCache != "" {
+		diskCache, err := stitch.NewDiskCache(*flagTileCache)
+		if err != nil {
+			log.Panicf("Failed to set up tile cache: %v.", err)
+		}
+		stitch.DefaultDiskCache = diskCache
+	}
+
+	// Set up overlay sizes.
+	overlaySizeUnit, err := stitch.ParseOverlaySizeUnit(*flagOverlaySizeUnit)
+	if err != nil {
+		log.Panicf("Invalid overlay size unit: %v.", err)
+	}
+	playerPathDisplayWidth = stitch.OverlaySize{Value: *flagPlayerPathWidth, Unit: overlaySizeUnit}
+	playerPathMarkerRadius = stitch.OverlaySize{Value: *flagPlayerPathMarkerRadius, Unit: overlaySizeUnit}
+	entityDisplayLineWidth = stitch.OverlaySize{Value: *flagEntityLineWidth, Unit: overlaySizeUnit}
+	entityDisplayMarkerRadius = stitch.OverlaySize{Value: *flagEntityMarkerRadius, Unit: overlaySizeUnit}
+	gridDisplayLineWidth = stitch.OverlaySize{Value: *flagGridLineWidth, Unit: overlaySizeUnit}
+	gridDisplayFontSize = *flagGridFontSize
+	annotationDisplayLineWidth = stitch.OverlaySize{Value: *flagAnnotationLineWidth, Unit: overlaySizeUnit}
+	annotationDisplayIconRadius = stitch.OverlaySize{Value: *flagAnnotationIconRadius, Unit: overlaySizeUnit}
+	annotationDisplayFontSize = *flagAnnotationFontSize
+	mapInfoFontSize = *flagInfoFontSize
+
+	var shard stitch.Shard
+	if *flagShard != "" {
+		if shard, err = stitch.ParseShard(*flagShard); err != nil {
+			log.Panicf("Invalid shard: %v.", err)
+		}
+		if *flagMergeShards > 0 {
+			log.Panicf("A shard can't be exported and merged at the same time.")
+		}
+	}
+
+	var layers OverlayLayers
 
 	// Query the user, if there were no cmd arguments given.
 	if flag.NFlag() == 0 {
@@ -125,12 +234,41 @@ func main() {
 
 	// Load entities if requested.
 	entities, err := LoadEntities(*flagEntitiesInputPath)
-	if err != nil {
+	if recordsErr := (*JSONRecordsError)(nil); errors.As(err, &recordsErr) {
+		log.Printf("Entities file is damaged: %v.", err)
+	} else if err != nil {
 		log.Printf("Failed to load entities: %v.", err)
 	}
+	if len(entities) > 0 && *flagEntityDedupTolerance >= 0 {
+		keep, err := ParseEntityDedupKeep(*flagEntityDedupKeep)
+		if err != nil {
+			log.Panicf("Invalid entity deduplication mode: %v.", err)
+		}
+		var removed int
+		entities, removed = entities.Deduplicate(*flagEntityDedupTolerance, keep)
+		log.Printf("Removed %v duplicate entities.", removed)
+	}
 	if len(entities) > 0 {
 		log.Printf("Got %v entities.", len(entities))
-		overlays = append(overlays, entities) // Add entities to overlay drawing list.
+	}
+
+	// Create heat map if requested.
+	var heatMapOverlay *HeatMapOverlay
+	if *flagHeatMap || *flagHeatMapLayer {
+		colorRamp, err := ParseColorRamp(*flagHeatMapColors)
+		if err != nil {
+			log.Panicf("Invalid heat map colors: %v.", err)
+		}
+		heatMapEntities := ParseEntityFilter(*flagHeatMapTags, *flagHeatMapComponents).Filter(entities)
+		log.Printf("Got %v entities for the heat map.", len(heatMapEntities))
+		if heatMapOverlay, err = NewHeatMapOverlay(heatMapEntities, *flagHeatMapRadius, *flagHeatMapMax, colorRamp); err != nil {
+			log.Panicf("Failed to create heat map: %v.", err)
+		}
+		layers = append(layers, OverlayLayer{Name: "heatmap", Overlay: heatMapOverlay}) // Add heat map to overlay drawing list, below entities.
+	}
+
+	if len(entities) > 0 {
+		layers = append(layers, OverlayLayer{Name: "entities", Overlay: NewEntitiesOverlay(entities)}) // Add entities to overlay drawing list.
 	}
 
 	// Query the user, if there were no cmd arguments given.
@@ -150,17 +288,49 @@ func main() {
 
 	// Load player path if requested.
 	playerPath, err := LoadPlayerPath(*flagPlayerPathInputPath)
-	if err != nil {
+	if recordsErr := (*JSONRecordsError)(nil); errors.As(err, &recordsErr) {
+		log.Printf("Player path file is damaged: %v.", err)
+	} else if err != nil {
 		log.Printf("Failed to load player path: %v.", err)
 	}
+	if len(playerPath) > 0 && *flagPlayerPathSimplify > 0 {
+		playerPath = playerPath.Simplify(*flagPlayerPathSimplify)
+		log.Printf("Simplified player path to %v entries.", len(playerPath))
+	}
 	if len(playerPath) > 0 {
 		log.Printf("Got %v player path entries.", len(playerPath))
-		overlays = append(overlays, playerPath) // Add player path to overlay drawing list.
+		playerPathStyle := DefaultPlayerPathStyle
+		if playerPathStyle.Coloring, err = ParsePlayerPathColoring(*flagPlayerPathColoring); err != nil {
+			log.Panicf("Invalid player path coloring: %v.", err)
+		}
+		if playerPathStyle.ColorRamp, err = ParseColorRamp(*flagPlayerPathColors); err != nil {
+			log.Panicf("Invalid player path colors: %v.", err)
+		}
+		if playerPathStyle.Coloring == PlayerPathColoringTime && !playerPath.HasFrames() {
+			log.Printf("Player path doesn't contain any timing information, coloring it by segment index instead.")
+		}
+		playerPathStyle.Markers = *flagPlayerPathMarkers
+		playerPathStyle.HPDropThreshold = *flagPlayerPathHPDrop
+		playerPathStyle.TeleportDistance = *flagPlayerPathTeleportDistance
+		layers = append(layers, OverlayLayer{Name: "player-path", Overlay: NewPlayerPathOverlay(playerPath, playerPathStyle)}) // Add player path to overlay drawing list.
+	}
+
+	// Load annotations if requested.
+	var annotations Annotations
+	if *flagAnnotations != "" {
+		if annotations, err = LoadAnnotations(*flagAnnotations); err != nil {
+			log.Panicf("Failed to load annotations: %v.", err)
+		}
+		log.Printf("Got %v annotations.", len(annotations))
+		layers = append(layers, OverlayLayer{Name: "annotations", Overlay: NewAnnotationsOverlay(annotations)}) // Add annotations to overlay drawing list.
 	}
 
 	log.Printf("Starting to read tile information at %q.", *flagInputPath)
-	tiles, err := LoadImageTiles(*flagInputPath, *flagScaleDivider)
-	if err != nil {
+	tiles, err := stitch.LoadImageTiles(ctx, *flagInputPath, *flagScaleDivider)
+	if errors.Is(err, context.Canceled) {
+		log.Printf("Reading tile information was interrupted.")
+		return
+	} else if err != nil {
 		log.Panic(err)
 	}
 	if len(tiles) == 0 {
@@ -233,6 +403,38 @@ func main() {
 
 	fileExtension := strings.ToLower(filepath.Ext(*flagOutputPath))
 
+	// Create grid overlay if requested.
+	// This needs to know the output rectangle, as labels are placed at its edges.
+	if *flagGrid != 0 || *flagGridRegions != "" {
+		var regions Regions
+		if *flagGridRegions != "" {
+			if regions, err = LoadRegions(*flagGridRegions); err != nil {
+				log.Panicf("Failed to load grid regions: %v.", err)
+			}
+		}
+		worldBounds := image.Rectangle{outputRect.Min.Mul(*flagScaleDivider), outputRect.Max.Mul(*flagScaleDivider)}
+		gridOverlay, err := NewGridOverlay(worldBounds, *flagGrid, image.Point{*flagGridOffsetX, *flagGridOffsetY}, *flagGridLabels, regions)
+		if err != nil {
+			log.Panicf("Failed to create grid overlay: %v.", err)
+		}
+		layers = append(layers, OverlayLayer{Name: "grid", Overlay: gridOverlay})
+	}
+
+	// Create map info overlay if requested.
+	// This is added last, so it's drawn on top of all other overlays.
+	if *flagTitle != "" || *flagLegend || *flagScaleBar {
+		corner, err := ParseMapInfoCorner(*flagInfoCorner)
+		if err != nil {
+			log.Panicf("Invalid map info corner: %v.", err)
+		}
+		var legendOverlays []stitch.StitchedImageOverlay
+		if *flagLegend {
+			legendOverlays = layers.Overlays()
+		}
+		worldBounds := image.Rectangle{outputRect.Min.Mul(*flagScaleDivider), outputRect.Max.Mul(*flagScaleDivider)}
+		layers = append(layers, OverlayLayer{Name: "info", Overlay: NewMapInfoOverlay(worldBounds, corner, *flagTitle, legendOverlays, *flagScaleBar)})
+	}
+
 	// Query the user, if there were no cmd arguments given.
 	if flag.NFlag() == 0 && fileExtension == ".dzi" {
 		prompt := promptui.Prompt{
@@ -317,39 +519,82 @@ func main() {
 		fmt.Sscanf(result, "%d", flagWebPLevel)
 	}
 
-	blendMethod := BlendMethodMedian{
+	var blendMethod stitch.StitchedImageBlendMethod = stitch.BlendMethodMedian{
 		BlendTileLimit: *flagBlendTileLimit, // Limit median blending to the n newest tiles by file modification time.
 	}
 
-	stitchedImage, err := NewStitchedImage(tiles, outputRect, blendMethod, 128, overlays)
+	// Export only the heat map, if requested.
+	// The tiles are still needed to determine the output rectangle, so that the layer lines up with a stitched image.
+	if *flagHeatMapLayer {
+		if fileExtension == ".jpg" || fileExtension == ".jpeg" {
+			log.Panicf("Heat map layers can't be exported as JPEG, as it doesn't support transparency.")
+		}
+		blendMethod = stitch.BlendMethodTransparent{}
+		layers = OverlayLayers{{Name: "heatmap", Overlay: heatMapOverlay}}
+	}
+
+	// Either draw the overlays into the output, or export them as separate layers.
+	overlays := layers.Overlays()
+	var layerNames []string
+	if *flagLayers && !*flagHeatMapLayer {
+		if fileExtension == ".jpg" || fileExtension == ".jpeg" {
+			log.Panicf("Layers can't be exported as JPEG, as it doesn't support transparency.")
+		}
+		overlays = nil
+		for _, layer := range layers {
+			layerNames = append(layerNames, layer.Name)
+		}
+	}
+
+	stitchedImage, err := stitch.NewStitchedImage(ctx, tiles, outputRect, blendMethod, 128, overlays, *flagScaleDivider)
 	if err != nil {
 		log.Panicf("NewStitchedImage() failed: %v.", err)
 	}
+	defer stitchedImage.Close()
 
-	bar := pb.Full.New(0)
+	startTime := time.Now()
 
-	switch fileExtension {
-	case ".png":
-		if err := exportPNGStitchedImage(stitchedImage, *flagOutputPath, bar); err != nil {
-			log.Panicf("Export of PNG file failed: %v", err)
+	// Export only a part of the output, the layers are exported when the shards are merged.
+	if *flagShard != "" {
+		if err := exportShard(ctx, stitchedImage, *flagOutputPath, shard, pb.Full.New(0), *flagDZITileSize, *flagDZIOverlap, *flagWebPLevel); errors.Is(err, context.Canceled) {
+			log.Printf("Export of shard %v was interrupted, run it again to resume.", shard)
+			return
+		} else if err != nil {
+			log.Panicf("Failed to export shard %v: %v.", shard, err)
 		}
-	case ".jpg", ".jpeg":
-		if err := exportJPEGStitchedImage(stitchedImage, *flagOutputPath, bar); err != nil {
-			log.Panicf("Export of JPEG file failed: %v", err)
-		}
-	case ".webp":
-		if err := exportWebPStitchedImage(stitchedImage, *flagOutputPath, bar, *flagWebPLevel); err != nil {
-			log.Panicf("Export of WebP file failed: %v", err)
+
+		log.Printf("Created shard %v in %v. Once all shards are done, merge them with -merge-shards %d.", shard, time.Since(startTime), shard.Count)
+		return
+	}
+
+	if *flagMergeShards > 0 {
+		if err := mergeShards(ctx, stitchedImage, *flagOutputPath, *flagMergeShards, pb.Full.New(0), *flagDZITileSize, *flagDZIOverlap, *flagWebPLevel, annotations, layerNames); errors.Is(err, context.Canceled) {
+			log.Printf("Merging shards was interrupted, no output was written.")
+			return
+		} else if err != nil {
+			log.Panicf("Failed to merge shards: %v.", err)
 		}
-	case ".dzi":
-		if err := exportDZIStitchedImage(stitchedImage, *flagOutputPath, bar, *flagDZITileSize, *flagDZIOverlap, *flagWebPLevel); err != nil {
-			log.Panicf("Export of DZI file failed: %v", err)
+	} else if err := exportStitchedImage(ctx, stitchedImage, *flagOutputPath, pb.Full.New(0), *flagDZITileSize, *flagDZIOverlap, *flagWebPLevel, annotations, layerNames); errors.Is(err, context.Canceled) {
+		log.Printf("Export was interrupted, no output was written.")
+		return
+	} else if err != nil {
+		log.Panicf("Failed to export stitched image: %v.", err)
+	}
+
+	if layerNames != nil {
+		if err := exportLayers(ctx, layers, outputRect, *flagScaleDivider, *flagOutputPath, *flagDZITileSize, *flagDZIOverlap, *flagWebPLevel); errors.Is(err, context.Canceled) {
+			log.Printf("Export was interrupted, not all layers were written.")
+			return
+		} else if err != nil {
+			log.Panicf("Failed to export layers: %v.", err)
 		}
-	default:
-		log.Panicf("Unknown output format %q.", fileExtension)
 	}
 
-	log.Printf("Created output in %v.", time.Since(bar.StartTime()))
+	log.Printf("Created output in %v.", time.Since(startTime))
+	log.Printf("Cache statistics: %v.", stitch.DefaultCache.Stats())
+	if stitch.DefaultDiskCache != nil {
+		log.Printf("Tile cache statistics: %v.", stitch.DefaultDiskCache.Stats())
+	}
 
 	//fmt.Println("Press the enter key to terminate the console screen!")
 	//fmt.Scanln()
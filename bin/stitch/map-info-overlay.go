@@ -0,0 +1,272 @@
+// Copyright (c) 2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/Dadido3/noita-mapcap/pkg/stitch"
+	"github.com/tdewolff/canvas"
+)
+
+// LegendEntry is a single entry of a map legend.
+type LegendEntry struct {
+	Label  string
+	Symbol func(c *canvas.Context, x, y, size float64) // Draws the symbol centered at x, y in output coordinates, so that it fits into a square with the given side length.
+}
+
+// LegendProvider is implemented by overlays that can describe their symbols in a map legend.
+type LegendProvider interface {
+	LegendEntries() []LegendEntry
+}
+
+// legendSwatch returns a legend symbol that is a square filled and stroked with the given style.
+func legendSwatch(style canvas.Style) func(c *canvas.Context, x, y, size float64) {
+	return func(c *canvas.Context, x, y, size float64) {
+		c.Style = style
+		c.Style.StrokeWidth = math.Max(size/10, 1)
+		c.DrawPath(x-size/2, y-size/2, canvas.Rectangle(size, size))
+	}
+}
+
+// legendLine returns a legend symbol that is a horizontal line with the given colors from left to right.
+func legendLine(lineColors ...color.Color) func(c *canvas.Context, x, y, size float64) {
+	return func(c *canvas.Context, x, y, size float64) {
+		c.ResetStyle()
+		c.SetStrokeWidth(math.Max(size/4, 1))
+		c.SetStrokeCapper(canvas.ButtCap)
+		segment := size / float64(len(lineColors))
+		for i, lineColor := range lineColors {
+			c.SetStrokeColor(lineColor)
+			c.DrawPath(x-size/2+float64(i)*segment, y, canvas.Line(segment, 0))
+		}
+	}
+}
+
+// legendRamp returns the colors of the given color ramp at some evenly spaced positions.
+func legendRamp(colorRamp ColorRamp) []color.Color {
+	const steps = 8
+	result := make([]color.Color, 0, steps)
+	for i := 0; i < steps; i++ {
+		result = append(result, colorRamp.At(float64(i)/(steps-1)))
+	}
+	return result
+}
+
+// MapInfoCorner defines in which corner the map info is placed.
+type MapInfoCorner int
+
+const (
+	MapInfoCornerTopLeft MapInfoCorner = iota
+	MapInfoCornerTopRight
+	MapInfoCornerBottomLeft
+	MapInfoCornerBottomRight
+)
+
+// ParseMapInfoCorner returns the corner that corresponds to the given name.
+func ParseMapInfoCorner(name string) (MapInfoCorner, error) {
+	switch name {
+	case "top-left":
+		return MapInfoCornerTopLeft, nil
+	case "top-right":
+		return MapInfoCornerTopRight, nil
+	case "bottom-left":
+		return MapInfoCornerBottomLeft, nil
+	case "bottom-right":
+		return MapInfoCornerBottomRight, nil
+	}
+
+	return 0, fmt.Errorf("unknown corner %q", name)
+}
+
+// mapInfoFontSize is the font size of the map info in output pixels.
+var mapInfoFontSize = 14.0
+
+// mapInfoScaleBarLength is the preferred length of the scale bar in output pixels.
+// The real length will be somewhere between 40% and 100% of this, as only round numbers are used.
+const mapInfoScaleBarLength = 200.0
+
+var (
+	mapInfoBackgroundColor = color.RGBA{0, 0, 0, 160}
+	mapInfoTextColor       = color.RGBA{255, 255, 255, 255}
+)
+
+// MapInfoOverlay draws a block with a title, a legend and a scale bar in a corner of the output.
+// In DZI exports, this is a corner of every zoom level and not of the current view.
+// The block has the same size on every zoom level of a DZI.
+type MapInfoOverlay struct {
+	worldBounds image.Rectangle // The output rectangle in world coordinates. The block is placed in one of its corners.
+	corner      MapInfoCorner
+	title       []string
+	legend      []LegendEntry
+	scaleBar    bool
+}
+
+// NewMapInfoOverlay returns an overlay that draws the given title lines, a legend and a scale bar into a corner of the output.
+//
+// worldBounds is the rectangle of the whole output in world coordinates.
+// The title can contain the placeholders `{version}` and `{date}`, and lines are separated by newlines or `\n`.
+// The legend contains the entries of all given overlays that implement LegendProvider.
+func NewMapInfoOverlay(worldBounds image.Rectangle, corner MapInfoCorner, title string, legendOverlays []stitch.StitchedImageOverlay, scaleBar bool) *MapInfoOverlay {
+	m := &MapInfoOverlay{
+		worldBounds: worldBounds,
+		corner:      corner,
+		scaleBar:    scaleBar,
+	}
+
+	if title != "" {
+		title = strings.NewReplacer(`\n`, "\n", "{version}", version.String(), "{date}", time.Now().Format(time.DateOnly)).Replace(title)
+		m.title = strings.Split(title, "\n")
+	}
+
+	for _, overlay := range legendOverlays {
+		if provider, ok := overlay.(LegendProvider); ok {
+			m.legend = append(m.legend, provider.LegendEntries()...)
+		}
+	}
+
+	return m
+}
+
+// scaleBarWorldLength returns a round length in world pixels, so that the scale bar is close to mapInfoScaleBarLength output pixels.
+func scaleBarWorldLength(divider int) int {
+	maxLength := mapInfoScaleBarLength * float64(divider)
+	magnitude := math.Pow(10, math.Floor(math.Log10(maxLength)))
+	for _, factor := range []float64{5, 2, 1} {
+		if factor*magnitude <= maxLength {
+			return int(factor * magnitude)
+		}
+	}
+	return int(magnitude)
+}
+
+// Draw implements the StitchedImageOverlay interface.
+func (m *MapInfoOverlay) Draw(destImage *image.RGBA, scale stitch.OverlayScale) {
+	if len(m.title) == 0 && len(m.legend) == 0 && !m.scaleBar {
+		return
+	}
+
+	destRect := destImage.Bounds()
+	divider := scale.Divider
+
+	face := newOverlayFontFace(mapInfoFontSize)
+	defer face.Close()
+
+	// Layout in output pixels, relative to the top left corner of the block.
+	_, textHeight := measureOverlayText(face, "0")
+	padding := math.Round(mapInfoFontSize * 0.6)
+	lineHeight := math.Round(textHeight * 1.3)
+	symbolSize := math.Round(lineHeight * 0.7)
+	symbolGap := math.Round(mapInfoFontSize * 0.5)
+
+	scaleBarWorld := scaleBarWorldLength(divider)
+	scaleBarLength := float64(scaleBarWorld) / float64(divider)
+	scaleBarLabel := fmt.Sprintf("%d world px", scaleBarWorld)
+
+	width := 0.0
+	for _, line := range m.title {
+		lineWidth, _ := measureOverlayText(face, line)
+		width = math.Max(width, lineWidth)
+	}
+	for _, entry := range m.legend {
+		labelWidth, _ := measureOverlayText(face, entry.Label)
+		width = math.Max(width, symbolSize+symbolGap+labelWidth)
+	}
+	if m.scaleBar {
+		labelWidth, _ := measureOverlayText(face, scaleBarLabel)
+		width = math.Max(width, math.Max(scaleBarLength, labelWidth))
+	}
+
+	var sections []float64 // Height of every section.
+	if len(m.title) > 0 {
+		sections = append(sections, float64(len(m.title))*lineHeight)
+	}
+	if len(m.legend) > 0 {
+		sections = append(sections, float64(len(m.legend))*lineHeight)
+	}
+	if m.scaleBar {
+		sections = append(sections, lineHeight+symbolSize/2)
+	}
+	height := 0.0
+	for _, section := range sections {
+		height += section
+	}
+	height += float64(len(sections)-1) * padding
+
+	blockW, blockH := math.Ceil(width+2*padding), math.Ceil(height+2*padding)
+
+	// Place the block into the chosen corner of the output.
+	outputBounds := image.Rect(stitch.DivideFloor(m.worldBounds.Min.X, divider), stitch.DivideFloor(m.worldBounds.Min.Y, divider), stitch.DivideCeil(m.worldBounds.Max.X, divider), stitch.DivideCeil(m.worldBounds.Max.Y, divider))
+	margin := mapInfoFontSize
+	left, top := float64(outputBounds.Min.X)+margin, float64(outputBounds.Min.Y)+margin
+	if m.corner == MapInfoCornerTopRight || m.corner == MapInfoCornerBottomRight {
+		left = float64(outputBounds.Max.X) - margin - blockW
+	}
+	if m.corner == MapInfoCornerBottomLeft || m.corner == MapInfoCornerBottomRight {
+		top = float64(outputBounds.Max.Y) - margin - blockH
+	}
+
+	blockRect := image.Rect(int(math.Floor(left)), int(math.Floor(top)), int(math.Ceil(left+blockW)), int(math.Ceil(top+blockH)))
+	if !blockRect.Overlaps(destRect) {
+		return
+	}
+
+	c, ctx := newOverlayCanvas(destImage)
+
+	// Background.
+	ctx.SetFillColor(mapInfoBackgroundColor)
+	ctx.SetStrokeColor(canvas.Transparent)
+	ctx.DrawPath(left, top, canvas.Rectangle(blockW, blockH))
+
+	// Legend symbols and scale bar.
+	y := top + padding
+	if len(m.title) > 0 {
+		y += float64(len(m.title))*lineHeight + padding
+	}
+	for i, entry := range m.legend {
+		if entry.Symbol != nil {
+			entry.Symbol(ctx, left+padding+symbolSize/2, y+float64(i)*lineHeight+lineHeight/2, symbolSize)
+		}
+	}
+	if len(m.legend) > 0 {
+		y += float64(len(m.legend))*lineHeight + padding
+	}
+	if m.scaleBar {
+		barHeight := symbolSize / 2
+		ctx.ResetStyle()
+		ctx.SetStrokeColor(mapInfoTextColor)
+		ctx.SetStrokeWidth(1)
+		ctx.SetFillColor(mapInfoTextColor)
+		ctx.DrawPath(left+padding, y, canvas.Rectangle(scaleBarLength/2, barHeight))
+		ctx.SetFillColor(canvas.Transparent)
+		ctx.DrawPath(left+padding+scaleBarLength/2, y, canvas.Rectangle(scaleBarLength/2, barHeight))
+	}
+
+	renderOverlayCanvas(c, destImage)
+
+	// Text.
+	y = top + padding
+	for i, line := range m.title {
+		drawOverlayText(destImage, face, line, left+padding, y+float64(i)*lineHeight+lineHeight/2, 0, 0.5, mapInfoTextColor, nil)
+	}
+	if len(m.title) > 0 {
+		y += float64(len(m.title))*lineHeight + padding
+	}
+	for i, entry := range m.legend {
+		drawOverlayText(destImage, face, entry.Label, left+padding+symbolSize+symbolGap, y+float64(i)*lineHeight+lineHeight/2, 0, 0.5, mapInfoTextColor, nil)
+	}
+	if len(m.legend) > 0 {
+		y += float64(len(m.legend))*lineHeight + padding
+	}
+	if m.scaleBar {
+		drawOverlayText(destImage, face, scaleBarLabel, left+padding, y+symbolSize/2+lineHeight/2, 0, 0.5, mapInfoTextColor, nil)
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright (c) 2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"image"
+
+	"github.com/tdewolff/canvas"
+	"github.com/tdewolff/canvas/renderers/rasterizer"
+)
+
+// newOverlayCanvas returns a canvas and its context that can be used to draw onto destImage.
+// The coordinate system of the context is set up to match the output coordinates of destImage.
+//
+// Coordinates and paths are not scaled by the context, they have to be converted from world to output coordinates before drawing.
+// This way any stroke width is always given in output pixels.
+func newOverlayCanvas(destImage *image.RGBA) (*canvas.Canvas, *canvas.Context) {
+	destRect := destImage.Bounds()
+
+	c := canvas.New(float64(destRect.Dx()), float64(destRect.Dy()))
+	ctx := canvas.NewContext(c)
+	ctx.SetCoordSystem(canvas.CartesianIV)
+	ctx.SetCoordRect(canvas.Rect{X: -float64(destRect.Min.X), Y: -float64(destRect.Min.Y), W: float64(destRect.Dx()), H: float64(destRect.Dy())}, float64(destRect.Dx()), float64(destRect.Dy()))
+
+	return c, ctx
+}
+
+// renderOverlayCanvas rasterizes the given canvas into destImage.
+func renderOverlayCanvas(c *canvas.Canvas, destImage *image.RGBA) {
+	destRect := destImage.Bounds()
+
+	// Same as destImage, but top left is translated to (0, 0).
+	originImage := destImage.SubImage(destRect).(*image.RGBA)
+	originImage.Rect = originImage.Rect.Sub(destRect.Min)
+
+	// Theoretically we would need to linearize imgRGBA first, but DefaultColorSpace assumes that the color space is linear already.
+	r := rasterizer.FromImage(originImage, canvas.DPMM(1.0), canvas.DefaultColorSpace)
+	c.RenderTo(r)
+	r.Close() // This just transforms the image's luminance curve back from linear into non linear.
+}
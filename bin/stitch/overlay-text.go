@@ -0,0 +1,82 @@
+// Copyright (c) 2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"log"
+	"math"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// overlayFont is the font used for all overlay labels.
+// It is parsed once on first use.
+var overlayFont = sync.OnceValue(func() *opentype.Font {
+	f, err := opentype.Parse(gomono.TTF)
+	if err != nil {
+		log.Panicf("Failed to parse overlay font: %v.", err)
+	}
+	return f
+})
+
+// newOverlayFontFace returns a font face with the given size in output pixels.
+// Font faces can't be used concurrently, so every Draw call should create its own.
+func newOverlayFontFace(size float64) font.Face {
+	face, err := opentype.NewFace(overlayFont(), &opentype.FaceOptions{
+		Size:    math.Max(size, 1),
+		DPI:     72, // With 72 DPI the size is in pixels.
+		Hinting: font.HintingFull,
+	})
+	if err != nil {
+		log.Panicf("Failed to create overlay font face: %v.", err)
+	}
+	return face
+}
+
+// measureOverlayText returns the width and height of the given text in output pixels.
+func measureOverlayText(face font.Face, text string) (width, height float64) {
+	metrics := face.Metrics()
+	return float64(font.MeasureString(face, text)) / 64, float64(metrics.Ascent+metrics.Descent) / 64
+}
+
+// drawOverlayText draws the given text with an outline onto destImage.
+//
+// x and y are in output coordinates.
+// alignX and alignY define which point of the text is placed at x and y, where 0 is the left or top and 1 is the right or bottom edge of the text.
+func drawOverlayText(destImage *image.RGBA, face font.Face, text string, x, y, alignX, alignY float64, textColor, outlineColor color.Color) {
+	width, height := measureOverlayText(face, text)
+	ascent := float64(face.Metrics().Ascent) / 64
+
+	// Position of the baseline origin.
+	originX := math.Round(x - width*alignX)
+	originY := math.Round(y - height*alignY + ascent)
+
+	drawer := font.Drawer{Dst: destImage, Face: face}
+
+	// Stop early if the text is not inside destImage.
+	textRect := image.Rect(int(originX)-1, int(originY-ascent)-1, int(originX+width)+2, int(originY-ascent+height)+2)
+	if !textRect.Overlaps(destImage.Bounds()) {
+		return
+	}
+
+	if outlineColor != nil {
+		drawer.Src = image.NewUniform(outlineColor)
+		for _, offset := range [][2]int{{-1, -1}, {0, -1}, {1, -1}, {-1, 0}, {1, 0}, {-1, 1}, {0, 1}, {1, 1}} {
+			drawer.Dot = fixed.P(int(originX)+offset[0], int(originY)+offset[1])
+			drawer.DrawString(text)
+		}
+	}
+
+	drawer.Src = image.NewUniform(textColor)
+	drawer.Dot = fixed.P(int(originX), int(originY))
+	drawer.DrawString(text)
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"image"
+	"math"
+)
+
+// PlayerPathEventKind defines the type of a PlayerPathEvent.
+type PlayerPathEventKind int
+
+const (
+	PlayerPathEventStart          PlayerPathEventKind = iota // The beginning of the player path.
+	PlayerPathEventEnd                                       // The end of the player path.
+	PlayerPathEventHPDrop                                    // The player lost a lot of HP at once.
+	PlayerPathEventPolymorphStart                            // The player got polymorphed.
+	PlayerPathEventPolymorphEnd                              // The player is not polymorphed anymore.
+	PlayerPathEventTeleport                                  // The player moved a large distance at once.
+)
+
+// PlayerPathEvent is a notable point along the player path.
+type PlayerPathEvent struct {
+	Kind        PlayerPathEventKind
+	Index       int        // Index of the path element the event belongs to.
+	Position    [2]float64 // Position of the event in world coordinates.
+	Destination [2]float64 // The position the player teleported to. Only used for PlayerPathEventTeleport.
+	HPLoss      float64    // The amount of lost HP. Only used for PlayerPathEventHPDrop.
+}
+
+// Bounds returns the bounding box of the event in world coordinates.
+// For teleports this includes the destination.
+func (e PlayerPathEvent) Bounds() image.Rectangle {
+	to := e.Position
+	if e.Kind == PlayerPathEventTeleport {
+		to = e.Destination
+	}
+
+	return PlayerPathElement{From: e.Position, To: to}.Bounds()
+}
+
+// Length returns the distance between the start and end point of the path element.
+func (p PlayerPathElement) Length() float64 {
+	return math.Hypot(p.To[0]-p.From[0], p.To[1]-p.From[1])
+}
+
+// HasFrames returns whether the path elements contain frame numbers.
+// Files written by older versions of the mod don't contain them.
+func (p PlayerPath) HasFrames() bool {
+	for _, pathElement := range p {
+		if pathElement.Frame != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// DurationFrames returns the number of game frames that every path element took.
+//
+// The duration of the first element, and of elements after the game was restarted, is not known and will be 0.
+// If the path elements don't contain any frame numbers, this returns nil.
+func (p PlayerPath) DurationFrames() []int64 {
+	if !p.HasFrames() {
+		return nil
+	}
+
+	result := make([]int64, len(p))
+	for i := 1; i < len(p); i++ {
+		if delta := p[i].Frame - p[i-1].Frame; delta > 0 {
+			result[i] = delta
+		}
+	}
+
+	return result
+}
+
+// ElapsedFrames returns the number of game frames that have passed since the beginning of the path, for the end of every path element.
+//
+// As the frame counter restarts with every game session, the time between sessions is not counted.
+// If the path elements don't contain any frame numbers, this returns nil.
+func (p PlayerPath) ElapsedFrames() []int64 {
+	result := p.DurationFrames()
+	for i := 1; i < len(result); i++ {
+		result[i] += result[i-1]
+	}
+
+	return result
+}
+
+// Events returns a list of notable points along the path, ordered by their path element index.
+//
+// hpDropThreshold is the fraction of the maximum HP that the player has to lose between two path elements to count as HP drop.
+// teleportDistance is the distance in world pixels the player has to travel between two consecutive positions to count as teleport.
+// This includes jumps between path elements that aren't connected, which happens when the game was restarted.
+func (p PlayerPath) Events(hpDropThreshold, teleportDistance float64) []PlayerPathEvent {
+	if len(p) == 0 {
+		return nil
+	}
+
+	result := []PlayerPathEvent{{Kind: PlayerPathEventStart, Index: 0, Position: p[0].From}}
+
+	for i, pathElement := range p {
+		if i > 0 {
+			prev := p[i-1]
+
+			if gap := (PlayerPathElement{From: prev.To, To: pathElement.From}); gap.Length() >= teleportDistance {
+				result = append(result, PlayerPathEvent{Kind: PlayerPathEventTeleport, Index: i, Position: gap.From, Destination: gap.To})
+			}
+
+			if pathElement.MaxHP > 0 {
+				if hpLoss := prev.HP - pathElement.HP; hpLoss > 0 && hpLoss >= hpDropThreshold*pathElement.MaxHP {
+					result = append(result, PlayerPathEvent{Kind: PlayerPathEventHPDrop, Index: i, Position: pathElement.To, HPLoss: hpLoss})
+				}
+			}
+
+			if !prev.Polymorphed && pathElement.Polymorphed {
+				result = append(result, PlayerPathEvent{Kind: PlayerPathEventPolymorphStart, Index: i, Position: pathElement.From})
+			} else if prev.Polymorphed && !pathElement.Polymorphed {
+				result = append(result, PlayerPathEvent{Kind: PlayerPathEventPolymorphEnd, Index: i, Position: pathElement.From})
+			}
+		}
+
+		if pathElement.Length() >= teleportDistance {
+			result = append(result, PlayerPathEvent{Kind: PlayerPathEventTeleport, Index: i, Position: pathElement.From, Destination: pathElement.To})
+		}
+	}
+
+	result = append(result, PlayerPathEvent{Kind: PlayerPathEventEnd, Index: len(p) - 1, Position: p[len(p)-1].To})
+
+	return result
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"math"
+)
+
+// Simplify returns a simplified version of the player path, using the Douglas–Peucker algorithm.
+//
+// tolerance is the maximum distance in world pixels that the simplified path may deviate from the original path.
+// The path is only simplified between elements that are connected and have the same polymorph state, and where the player didn't lose any HP.
+// This ensures that the coloring and all events stay where they are.
+// Every resulting element takes the HP and frame number of the last element it replaces.
+func (p PlayerPath) Simplify(tolerance float64) PlayerPath {
+	if tolerance <= 0 || len(p) == 0 {
+		return p
+	}
+
+	result := make(PlayerPath, 0, len(p))
+
+	// Split the path into runs of elements that can be merged, and simplify them one by one.
+	runStart := 0
+	for i := 1; i <= len(p); i++ {
+		if i < len(p) {
+			prev, pathElement := p[i-1], p[i]
+			if prev.To == pathElement.From && prev.Polymorphed == pathElement.Polymorphed && pathElement.HP >= prev.HP {
+				continue
+			}
+		}
+
+		result = append(result, p[runStart:i].simplifyRun(tolerance)...)
+		runStart = i
+	}
+
+	return result
+}
+
+// simplifyRun simplifies a list of connected path elements.
+func (p PlayerPath) simplifyRun(tolerance float64) PlayerPath {
+	if len(p) < 2 {
+		return p
+	}
+
+	// The points of the run, point i is the start of element i, and the last point is the end of the last element.
+	points := make([][2]float64, 0, len(p)+1)
+	for _, pathElement := range p {
+		points = append(points, pathElement.From)
+	}
+	points = append(points, p[len(p)-1].To)
+
+	keep := make([]bool, len(points))
+	keep[0], keep[len(points)-1] = true, true
+
+	// Iterative version of the Douglas–Peucker algorithm, so long runs don't cause deep recursion.
+	type span struct{ first, last int }
+	stack := []span{{0, len(points) - 1}}
+	for len(stack) > 0 {
+		s := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		maxDist, maxIndex := 0.0, -1
+		for i := s.first + 1; i < s.last; i++ {
+			if dist := pointSegmentDistance(points[i], points[s.first], points[s.last]); dist > maxDist {
+				maxDist, maxIndex = dist, i
+			}
+		}
+
+		if maxIndex >= 0 && maxDist > tolerance {
+			keep[maxIndex] = true
+			stack = append(stack, span{s.first, maxIndex}, span{maxIndex, s.last})
+		}
+	}
+
+	var result PlayerPath
+	from := 0
+	for i := 1; i < len(points); i++ {
+		if !keep[i] {
+			continue
+		}
+		// Point i is the end of element i-1.
+		pathElement := p[i-1]
+		pathElement.From, pathElement.To = points[from], points[i]
+		result = append(result, pathElement)
+		from = i
+	}
+
+	return result
+}
+
+// pointSegmentDistance returns the distance between the point p and the line segment from a to b.
+func pointSegmentDistance(p, a, b [2]float64) float64 {
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	lengthSqr := dx*dx + dy*dy
+	if lengthSqr == 0 {
+		return math.Hypot(p[0]-a[0], p[1]-a[1])
+	}
+
+	t := ((p[0]-a[0])*dx + (p[1]-a[1])*dy) / lengthSqr
+	t = math.Max(0, math.Min(1, t))
+
+	return math.Hypot(p[0]-(a[0]+t*dx), p[1]-(a[1]+t*dy))
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestPlayerPathSimplify(t *testing.T) {
+	// connected returns a connected path through the given points, with increasing frame numbers starting at firstFrame.
+	connected := func(firstFrame int64, points ...[2]float64) PlayerPath {
+		var p PlayerPath
+		for i := 1; i < len(points); i++ {
+			p = append(p, PlayerPathElement{From: points[i-1], To: points[i], HP: 100, MaxHP: 100, Frame: firstFrame + int64(i)})
+		}
+		return p
+	}
+	// join concatenates the given paths.
+	join := func(paths ...PlayerPath) PlayerPath { return slices.Concat(paths...) }
+	// modify returns a copy of the path where f was applied to every element.
+	modify := func(p PlayerPath, f func(*PlayerPathElement)) PlayerPath {
+		p = slices.Clone(p)
+		for i := range p {
+			f(&p[i])
+		}
+		return p
+	}
+
+	straight := connected(0, [2]float64{0, 0}, [2]float64{10, 0}, [2]float64{20, 0}, [2]float64{30, 0})
+	straightSimplified := PlayerPath{{From: [2]float64{0, 0}, To: [2]float64{30, 0}, HP: 100, MaxHP: 100, Frame: 3}}
+
+	tests := []struct {
+		name      string
+		path      PlayerPath
+		tolerance float64
+		want      PlayerPath
+	}{
+		{name: "empty", path: nil, tolerance: 1, want: nil},
+		{name: "no tolerance", path: straight, tolerance: 0, want: straight},
+		{name: "single element", path: straight[:1], tolerance: 1, want: straight[:1]},
+		{name: "straight line", path: straight, tolerance: 1, want: straightSimplified},
+		{name: "deviation at tolerance",
+			path:      connected(0, [2]float64{0, 0}, [2]float64{10, 2}, [2]float64{20, 0}),
+			tolerance: 2,
+			want:      PlayerPath{{From: [2]float64{0, 0}, To: [2]float64{20, 0}, HP: 100, MaxHP: 100, Frame: 2}}},
+		{name: "deviation beyond tolerance",
+			path:      connected(0, [2]float64{0, 0}, [2]float64{10, 2.5}, [2]float64{20, 0}),
+			tolerance: 2,
+			want:      connected(0, [2]float64{0, 0}, [2]float64{10, 2.5}, [2]float64{20, 0})},
+		{name: "only the outlier is kept",
+			path:      connected(0, [2]float64{0, 0}, [2]float64{10, 0}, [2]float64{20, 50}, [2]float64{30, 0}, [2]float64{40, 0}),
+			tolerance: 1,
+			want: PlayerPath{
+				{From: [2]float64{0, 0}, To: [2]float64{10, 0}, HP: 100, MaxHP: 100, Frame: 1},
+				{From: [2]float64{10, 0}, To: [2]float64{20, 50}, HP: 100, MaxHP: 100, Frame: 2},
+				{From: [2]float64{20, 50}, To: [2]float64{30, 0}, HP: 100, MaxHP: 100, Frame: 3},
+				{From: [2]float64{30, 0}, To: [2]float64{40, 0}, HP: 100, MaxHP: 100, Frame: 4},
+			}},
+		{name: "break at disconnect",
+			path:      join(straight, connected(10, [2]float64{100, 0}, [2]float64{110, 0}, [2]float64{120, 0})),
+			tolerance: 1,
+			want:      join(straightSimplified, PlayerPath{{From: [2]float64{100, 0}, To: [2]float64{120, 0}, HP: 100, MaxHP: 100, Frame: 12}})},
+		{name: "break at polymorph change",
+			path:      join(straight, modify(connected(10, [2]float64{30, 0}, [2]float64{40, 0}, [2]float64{50, 0}), func(e *PlayerPathElement) { e.Polymorphed = true })),
+			tolerance: 1,
+			want:      join(straightSimplified, PlayerPath{{From: [2]float64{30, 0}, To: [2]float64{50, 0}, HP: 100, MaxHP: 100, Polymorphed: true, Frame: 12}})},
+		{name: "break at HP loss",
+			path:      join(straight, modify(connected(10, [2]float64{30, 0}, [2]float64{40, 0}, [2]float64{50, 0}), func(e *PlayerPathElement) { e.HP = 90 })),
+			tolerance: 1,
+			want:      join(straightSimplified, PlayerPath{{From: [2]float64{30, 0}, To: [2]float64{50, 0}, HP: 90, MaxHP: 100, Frame: 12}})},
+		{name: "no break at HP gain",
+			path:      join(modify(straight, func(e *PlayerPathElement) { e.HP = 90 }), connected(10, [2]float64{30, 0}, [2]float64{40, 0}, [2]float64{50, 0})),
+			tolerance: 1,
+			want:      PlayerPath{{From: [2]float64{0, 0}, To: [2]float64{50, 0}, HP: 100, MaxHP: 100, Frame: 12}}},
+		{name: "break at every HP loss",
+			path:      modify(straight, func(e *PlayerPathElement) { e.HP = 100 - float64(e.Frame) }),
+			tolerance: 1,
+			want:      modify(straight, func(e *PlayerPathElement) { e.HP = 100 - float64(e.Frame) })},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.path.Simplify(tt.tolerance); !slices.Equal(got, tt.want) {
+				t.Errorf("Got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
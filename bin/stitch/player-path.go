@@ -6,16 +6,19 @@
 package main
 
 import (
-	"encoding/json"
+	"fmt"
 	"image"
 	"image/color"
 	"math"
 	"os"
 
+	"github.com/Dadido3/noita-mapcap/pkg/stitch"
 	"github.com/tdewolff/canvas"
-	"github.com/tdewolff/canvas/renderers/rasterizer"
 )
 
+// playerPathDisplayWidth is the stroke width of the player path.
+var playerPathDisplayWidth = stitch.OverlaySize{Value: 3.0}
+
 var playerPathDisplayStyle = canvas.Style{
 	Fill: canvas.Paint{},
 	//Stroke:       canvas.Paint{Color: color.RGBA{0, 0, 0, 127}},
@@ -33,69 +36,316 @@ type PlayerPathElement struct {
 	HP          float64    `json:"hp"`
 	MaxHP       float64    `json:"maxHP"`
 	Polymorphed bool       `json:"polymorphed"`
+	Frame       int64      `json:"frame"` // The game frame number when this element was written. Older files don't contain this, and the game restarts counting with every session.
 }
 
 type PlayerPath []PlayerPathElement
 
+// LoadPlayerPath loads the player path from the JSON file at the given path.
+//
+// The file is decoded record by record, see DecodeJSONRecords.
+// If the file is damaged, all records before the damaged part are returned along with a *JSONRecordsError.
 func LoadPlayerPath(path string) (PlayerPath, error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
+	defer file.Close()
 
-	var result PlayerPath
+	return DecodeJSONRecords[PlayerPathElement](file)
+}
 
-	jsonDec := json.NewDecoder(file)
-	if err := jsonDec.Decode(&result); err != nil {
-		return nil, err
+// Bounds returns the bounding box of the path element in world coordinates.
+func (p PlayerPathElement) Bounds() image.Rectangle {
+	minX, minY := math.Floor(math.Min(p.From[0], p.To[0])), math.Floor(math.Min(p.From[1], p.To[1]))
+	maxX, maxY := math.Floor(math.Max(p.From[0], p.To[0])), math.Floor(math.Max(p.From[1], p.To[1]))
+
+	return image.Rect(int(minX), int(minY), int(maxX)+1, int(maxY)+1)
+}
+
+// PlayerPathColoring defines how the segments of the player path are colored.
+type PlayerPathColoring int
+
+const (
+	PlayerPathColoringHP    PlayerPathColoring = iota // Color segments from red to green depending on the HP, and purple when polymorphed.
+	PlayerPathColoringTime                            // Color segments along a color ramp depending on the elapsed time.
+	PlayerPathColoringIndex                           // Color segments along a color ramp depending on the segment index.
+)
+
+// ParsePlayerPathColoring returns the coloring that corresponds to the given name.
+func ParsePlayerPathColoring(name string) (PlayerPathColoring, error) {
+	switch name {
+	case "hp":
+		return PlayerPathColoringHP, nil
+	case "time":
+		return PlayerPathColoringTime, nil
+	case "index":
+		return PlayerPathColoringIndex, nil
+	}
+
+	return 0, fmt.Errorf("unknown player path coloring %q", name)
+}
+
+// DefaultPlayerPathColorRamp is a color ramp that goes from blue over green and yellow to red.
+const DefaultPlayerPathColorRamp = "#0000ff7f,#00ff007f,#ffff007f,#ff00007f"
+
+// PlayerPathStyle defines how the player path is drawn.
+type PlayerPathStyle struct {
+	Coloring  PlayerPathColoring
+	ColorRamp ColorRamp // Used by PlayerPathColoringTime and PlayerPathColoringIndex.
+
+	Markers          bool    // Draw markers at the start and end of the path, and for all events.
+	HPDropThreshold  float64 // The fraction of the maximum HP that has to be lost at once to be marked.
+	TeleportDistance float64 // The distance in world pixels that has to be traveled at once to be marked as teleport.
+}
+
+// DefaultPlayerPathStyle is the style that is used when nothing else is specified.
+var DefaultPlayerPathStyle = PlayerPathStyle{
+	HPDropThreshold:  0.25,
+	TeleportDistance: 512,
+}
+
+// playerPathMarkerRadius is the radius of the markers drawn along the player path.
+var playerPathMarkerRadius = stitch.OverlaySize{Value: 8.0}
+
+// PlayerPathOverlay draws the player path over the stitched image.
+type PlayerPathOverlay struct {
+	playerPath PlayerPath
+	index      *SpatialIndex
+	style      PlayerPathStyle
+
+	gradient []float64 // Position of every path element on the color ramp, from 0 to 1. Only used for gradient colorings.
+
+	events     []PlayerPathEvent
+	eventIndex *SpatialIndex
+	teleports  map[int]bool // Indices of path elements that are teleports. These are drawn by their event instead.
+}
+
+// NewPlayerPathOverlay returns an overlay that draws the given player path in the given style.
+// This builds a spatial index of all path elements, which is used to find the elements that need to be drawn.
+//
+// If the style uses PlayerPathColoringTime, but the path doesn't contain any frame numbers, the segment index is used instead.
+func NewPlayerPathOverlay(playerPath PlayerPath, style PlayerPathStyle) *PlayerPathOverlay {
+	bounds := make([]image.Rectangle, 0, len(playerPath))
+	for _, pathElement := range playerPath {
+		bounds = append(bounds, pathElement.Bounds())
+	}
+
+	p := &PlayerPathOverlay{
+		playerPath: playerPath,
+		index:      NewSpatialIndex(bounds, 512),
+		style:      style,
+		teleports:  map[int]bool{},
+	}
+
+	if style.Coloring != PlayerPathColoringHP && len(style.ColorRamp) > 0 && len(playerPath) > 0 {
+		p.gradient = make([]float64, len(playerPath))
+		elapsed := playerPath.ElapsedFrames()
+		if style.Coloring == PlayerPathColoringTime && elapsed != nil && elapsed[len(elapsed)-1] > 0 {
+			for i := range p.gradient {
+				p.gradient[i] = float64(elapsed[i]) / float64(elapsed[len(elapsed)-1])
+			}
+		} else if len(playerPath) > 1 {
+			for i := range p.gradient {
+				p.gradient[i] = float64(i) / float64(len(playerPath)-1)
+			}
+		}
+	}
+
+	if style.Markers {
+		p.events = playerPath.Events(style.HPDropThreshold, style.TeleportDistance)
+		eventBounds := make([]image.Rectangle, 0, len(p.events))
+		for _, event := range p.events {
+			eventBounds = append(eventBounds, event.Bounds())
+			if event.Kind == PlayerPathEventTeleport && event.Position == playerPath[event.Index].From && event.Destination == playerPath[event.Index].To {
+				p.teleports[event.Index] = true
+			}
+		}
+		p.eventIndex = NewSpatialIndex(eventBounds, 512)
 	}
 
-	return result, nil
+	return p
+}
+
+// segmentColor returns the stroke color of the path element with the given index.
+func (p *PlayerPathOverlay) segmentColor(i int) color.RGBA {
+	pathElement := p.playerPath[i]
+
+	if p.gradient != nil {
+		return color.RGBAModel.Convert(p.style.ColorRamp.At(p.gradient[i])).(color.RGBA)
+	}
+
+	if pathElement.Polymorphed {
+		// Set stroke color to typically polymorph color.
+		return color.RGBA{127, 50, 83, 127}
+	}
+
+	// Set stroke color depending on HP level.
+	hpFactor := math.Max(math.Min(pathElement.HP/pathElement.MaxHP, 1), 0)
+	hpFactorInv := 1 - hpFactor
+	r, g, b, a := uint8((0*hpFactor+1*hpFactorInv)*127), uint8((1*hpFactor+0*hpFactorInv)*127), uint8(0), uint8(127)
+	return color.RGBA{r, g, b, a}
 }
 
 // Draw implements the StitchedImageOverlay interface.
-func (p PlayerPath) Draw(destImage *image.RGBA) {
+func (p *PlayerPathOverlay) Draw(destImage *image.RGBA, scale stitch.OverlayScale) {
 	destRect := destImage.Bounds()
+	factor := 1 / float64(scale.Divider)
 
-	// Same as destImage, but top left is translated to (0, 0).
-	originImage := destImage.SubImage(destRect).(*image.RGBA)
-	originImage.Rect = originImage.Rect.Sub(destRect.Min)
-
-	c := canvas.New(float64(destRect.Dx()), float64(destRect.Dy()))
-	ctx := canvas.NewContext(c)
-	ctx.SetCoordSystem(canvas.CartesianIV)
-	ctx.SetCoordRect(canvas.Rect{X: -float64(destRect.Min.X), Y: -float64(destRect.Min.Y), W: float64(destRect.Dx()), H: float64(destRect.Dy())}, float64(destRect.Dx()), float64(destRect.Dy()))
+	c, ctx := newOverlayCanvas(destImage)
 
 	// Set drawing style.
 	ctx.Style = playerPathDisplayStyle
+	ctx.Style.StrokeWidth = playerPathDisplayWidth.OutputPixels(scale)
 
-	for _, pathElement := range p {
-		from, to := pathElement.From, pathElement.To
-
-		// Only draw if the path may cross the image rectangle.
-		pathRect := image.Rectangle{image.Point{int(from[0]), int(from[1])}, image.Point{int(to[0]), int(to[1])}}.Canon().Inset(int(-playerPathDisplayStyle.StrokeWidth) - 1)
-		if pathRect.Overlaps(destRect) {
-			path := &canvas.Path{}
-			path.MoveTo(from[0], from[1])
-			path.LineTo(to[0], to[1])
-
-			if pathElement.Polymorphed {
-				// Set stroke color to typically polymorph color.
-				ctx.Style.Stroke.Color = color.RGBA{127, 50, 83, 127}
-			} else {
-				// Set stroke color depending on HP level.
-				hpFactor := math.Max(math.Min(pathElement.HP/pathElement.MaxHP, 1), 0)
-				hpFactorInv := 1 - hpFactor
-				r, g, b, a := uint8((0*hpFactor+1*hpFactorInv)*127), uint8((1*hpFactor+0*hpFactorInv)*127), uint8(0), uint8(127)
-				ctx.Style.Stroke.Color = color.RGBA{r, g, b, a}
-			}
+	// The rectangle of destImage in world coordinates.
+	// Extended by the line width, as it is given in output pixels.
+	margin := int(math.Ceil(ctx.Style.StrokeWidth*float64(scale.Divider))) + 1
+	worldRect := image.Rectangle{destRect.Min.Mul(scale.Divider), destRect.Max.Mul(scale.Divider)}.Inset(-margin)
+
+	for _, i := range p.index.Query(worldRect) {
+		if p.teleports[i] {
+			continue
+		}
+		pathElement := p.playerPath[i]
+
+		// Convert into output coordinates.
+		from := [2]float64{pathElement.From[0] * factor, pathElement.From[1] * factor}
+		to := [2]float64{pathElement.To[0] * factor, pathElement.To[1] * factor}
+
+		path := &canvas.Path{}
+		path.MoveTo(from[0], from[1])
+		path.LineTo(to[0], to[1])
+
+		ctx.Style.Stroke.Color = p.segmentColor(i)
+
+		ctx.DrawPath(0, 0, path)
+	}
+
+	if p.eventIndex != nil {
+		radius := playerPathMarkerRadius.OutputPixels(scale)
+		lineWidth := math.Max(radius/4, 1)
+
+		// Markers are larger than the path, so query again with a bigger margin.
+		margin := int(math.Ceil((radius+lineWidth)*float64(scale.Divider))) + 1
+		worldRect := image.Rectangle{destRect.Min.Mul(scale.Divider), destRect.Max.Mul(scale.Divider)}.Inset(-margin)
+
+		for _, i := range p.eventIndex.Query(worldRect) {
+			drawPlayerPathEvent(ctx, p.events[i], factor, radius, lineWidth)
+		}
+	}
+
+	renderOverlayCanvas(c, destImage)
+}
 
-			ctx.DrawPath(0, 0, path)
+// drawPlayerPathEvent draws the marker of the given event.
+// factor converts world coordinates into output coordinates, radius and lineWidth are given in output pixels.
+func drawPlayerPathEvent(c *canvas.Context, event PlayerPathEvent, factor, radius, lineWidth float64) {
+	x, y := event.Position[0]*factor, event.Position[1]*factor
+
+	c.ResetStyle()
+	c.SetStrokeWidth(lineWidth)
+	c.SetStrokeColor(color.RGBA{0, 0, 0, 255})
+
+	switch event.Kind {
+	case PlayerPathEventStart:
+		// Green circle.
+		c.SetFillColor(color.RGBA{0, 200, 0, 255})
+		c.DrawPath(x, y, canvas.Circle(radius))
+
+	case PlayerPathEventEnd:
+		// Red square.
+		c.SetFillColor(color.RGBA{200, 0, 0, 255})
+		c.DrawPath(x-radius, y-radius, canvas.Rectangle(2*radius, 2*radius))
+
+	case PlayerPathEventHPDrop:
+		// Orange triangle pointing downwards.
+		c.SetFillColor(color.RGBA{255, 128, 0, 255})
+		c.DrawPath(x, y, canvas.RegularPolygon(3, radius, true))
+
+	case PlayerPathEventPolymorphStart:
+		// Filled purple diamond.
+		c.SetFillColor(color.RGBA{127, 50, 83, 255})
+		c.DrawPath(x, y, canvas.RegularPolygon(4, radius, true))
+
+	case PlayerPathEventPolymorphEnd:
+		// Hollow purple diamond.
+		c.SetFillColor(color.RGBA{255, 255, 255, 255})
+		c.SetStrokeColor(color.RGBA{127, 50, 83, 255})
+		c.DrawPath(x, y, canvas.RegularPolygon(4, radius, true))
+
+	case PlayerPathEventTeleport:
+		// Dashed line between two rings.
+		toX, toY := event.Destination[0]*factor, event.Destination[1]*factor
+		path := &canvas.Path{}
+		path.MoveTo(x, y)
+		path.LineTo(toX, toY)
+		c.SetFillColor(canvas.Transparent)
+		c.SetStrokeColor(color.RGBA{0, 200, 255, 200})
+		c.SetDashes(0, 4*lineWidth, 2*lineWidth)
+		c.DrawPath(0, 0, path)
+		c.SetDashes(0)
+
+		c.SetStrokeColor(color.RGBA{0, 200, 255, 255})
+		c.DrawPath(x, y, canvas.Circle(radius*0.75))
+		c.SetFillColor(color.RGBA{0, 200, 255, 255})
+		c.DrawPath(toX, toY, canvas.Circle(radius*0.75))
+	}
+}
+
+// playerPathEventLabels contains the legend labels of all event kinds.
+var playerPathEventLabels = map[PlayerPathEventKind]string{
+	PlayerPathEventStart:          "Start",
+	PlayerPathEventEnd:            "End",
+	PlayerPathEventHPDrop:         "HP drop",
+	PlayerPathEventPolymorphStart: "Polymorphed",
+	PlayerPathEventPolymorphEnd:   "Polymorph ended",
+	PlayerPathEventTeleport:       "Teleport",
+}
+
+// LegendEntries implements the LegendProvider interface.
+// Only event markers that appear along the path are listed.
+func (p *PlayerPathOverlay) LegendEntries() []LegendEntry {
+	if len(p.playerPath) == 0 {
+		return nil
+	}
+
+	var entries []LegendEntry
+	if p.gradient != nil {
+		entries = append(entries, LegendEntry{Label: "Player path (start to end)", Symbol: legendLine(legendRamp(p.style.ColorRamp)...)})
+	} else {
+		var hpColors []color.Color
+		for i := 0; i <= 4; i++ {
+			hpColors = append(hpColors, color.RGBA{uint8(127 - i*127/4), uint8(i * 127 / 4), 0, 127})
+		}
+		entries = append(entries,
+			LegendEntry{Label: "Player path (low to full HP)", Symbol: legendLine(hpColors...)},
+			LegendEntry{Label: "Player path (polymorphed)", Symbol: legendLine(color.RGBA{127, 50, 83, 127})},
+		)
+	}
+
+	used := map[PlayerPathEventKind]bool{}
+	for _, event := range p.events {
+		used[event.Kind] = true
+	}
+	for kind := PlayerPathEventStart; kind <= PlayerPathEventTeleport; kind++ {
+		if !used[kind] {
+			continue
 		}
+		kind := kind
+		entries = append(entries, LegendEntry{
+			Label: playerPathEventLabels[kind],
+			Symbol: func(c *canvas.Context, x, y, size float64) {
+				event := PlayerPathEvent{Kind: kind, Position: [2]float64{x, y}, Destination: [2]float64{x, y}}
+				radius := size / 2
+				if kind == PlayerPathEventTeleport {
+					radius = size / 4
+					event.Position[0], event.Destination[0] = x-size/2+radius, x+size/2-radius
+				}
+				drawPlayerPathEvent(c, event, 1, radius, math.Max(radius/4, 1))
+			},
+		})
 	}
 
-	// Theoretically we would need to linearize imgRGBA first, but DefaultColorSpace assumes that the color space is linear already.
-	r := rasterizer.FromImage(originImage, canvas.DPMM(1.0), canvas.DefaultColorSpace)
-	c.RenderTo(r)
-	r.Close() // This just transforms the image's luminance curve back from linear into non linear.
+	return entries
 }
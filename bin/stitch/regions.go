@@ -0,0 +1,73 @@
+// Copyright (c) 2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"os"
+)
+
+// Region is a named rectangle in world coordinates.
+type Region struct {
+	Name   string `json:"name"`
+	Left   int    `json:"left"`   // This coordinate is included in the region.
+	Top    int    `json:"top"`    // This coordinate is included in the region.
+	Right  int    `json:"right"`  // This coordinate is not included in the region.
+	Bottom int    `json:"bottom"` // This coordinate is not included in the region.
+}
+
+// Rect returns the rectangle of the region.
+func (r Region) Rect() image.Rectangle {
+	return image.Rect(r.Left, r.Top, r.Right, r.Bottom)
+}
+
+// Contains returns whether the given world coordinate is inside the region.
+func (r Region) Contains(x, y float64) bool {
+	return x >= float64(r.Left) && x < float64(r.Right) && y >= float64(r.Top) && y < float64(r.Bottom)
+}
+
+// Regions is a list of named rectangles.
+type Regions []Region
+
+// AreaRegions contains the capture areas of an unmodded `New Game` world, as listed in AREAS.md.
+var AreaRegions = Regions{
+	{Name: "Base layout", Left: -17920, Top: -7168, Right: 17920, Bottom: 17408},
+	{Name: "Main world", Left: -17920, Top: -31744, Right: 17920, Bottom: 41984},
+	{Name: "Extended", Left: -25600, Top: -31744, Right: 25600, Bottom: 41984},
+	{Name: "3 Worlds", Left: -53760, Top: -31744, Right: 53760, Bottom: 41984},
+}
+
+// LoadRegions loads a list of regions from the JSON file at the given path.
+//
+// The special path `areas` returns AreaRegions.
+func LoadRegions(path string) (Regions, error) {
+	if path == "areas" {
+		return AreaRegions, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var result Regions
+
+	jsonDec := json.NewDecoder(file)
+	if err := jsonDec.Decode(&result); err != nil {
+		return nil, err
+	}
+
+	for _, region := range result {
+		if region.Rect().Empty() {
+			return nil, fmt.Errorf("region %q is empty", region.Name)
+		}
+	}
+
+	return result, nil
+}
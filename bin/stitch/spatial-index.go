@@ -0,0 +1,87 @@
+// Copyright (c) 2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"image"
+	"slices"
+
+	"github.com/Dadido3/noita-mapcap/pkg/stitch"
+)
+
+// spatialIndexMaxCells is the maximum number of grid cells a single item is stored in.
+// Items that are larger are stored in a separate list that is checked on every query.
+const spatialIndexMaxCells = 1024
+
+// SpatialIndex maps rectangular areas to the items that overlap with them.
+// It's a uniform grid, where every item is stored in all the cells it overlaps with.
+//
+// Items are identified by their index in the list of bounds the index was created from.
+type SpatialIndex struct {
+	cellSize int
+	bounds   []image.Rectangle
+	cells    map[image.Point][]int
+	large    []int // Items that span too many cells.
+}
+
+// NewSpatialIndex creates a spatial index of the given item bounds.
+// The item with the index i has the bounds itemBounds[i].
+func NewSpatialIndex(itemBounds []image.Rectangle, cellSize int) *SpatialIndex {
+	s := &SpatialIndex{
+		cellSize: cellSize,
+		bounds:   itemBounds,
+		cells:    map[image.Point][]int{},
+	}
+
+	for i, bounds := range itemBounds {
+		cells := s.cellRect(bounds)
+		if cells.Dx()*cells.Dy() > spatialIndexMaxCells {
+			s.large = append(s.large, i)
+			continue
+		}
+
+		for y := cells.Min.Y; y < cells.Max.Y; y++ {
+			for x := cells.Min.X; x < cells.Max.X; x++ {
+				cell := image.Point{x, y}
+				s.cells[cell] = append(s.cells[cell], i)
+			}
+		}
+	}
+
+	return s
+}
+
+// cellRect returns the range of cells that overlap with the given rectangle.
+func (s *SpatialIndex) cellRect(rect image.Rectangle) image.Rectangle {
+	return image.Rect(stitch.DivideFloor(rect.Min.X, s.cellSize), stitch.DivideFloor(rect.Min.Y, s.cellSize), stitch.DivideCeil(rect.Max.X, s.cellSize), stitch.DivideCeil(rect.Max.Y, s.cellSize))
+}
+
+// Query returns the indices of all items that overlap with the given rectangle.
+// The result is sorted in ascending order, and doesn't contain any duplicates.
+func (s *SpatialIndex) Query(rect image.Rectangle) []int {
+	var result []int
+
+	cells := s.cellRect(rect)
+	for y := cells.Min.Y; y < cells.Max.Y; y++ {
+		for x := cells.Min.X; x < cells.Max.X; x++ {
+			for _, i := range s.cells[image.Point{x, y}] {
+				if s.bounds[i].Overlaps(rect) {
+					result = append(result, i)
+				}
+			}
+		}
+	}
+
+	for _, i := range s.large {
+		if s.bounds[i].Overlaps(rect) {
+			result = append(result, i)
+		}
+	}
+
+	// Items can be stored in several cells, so remove any duplicates.
+	slices.Sort(result)
+	return slices.Compact(result)
+}
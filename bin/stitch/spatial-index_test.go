@@ -0,0 +1,90 @@
+// Copyright (c) 2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"image"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func TestSpatialIndexQuery(t *testing.T) {
+	const cellSize = 16
+
+	itemBounds := []image.Rectangle{
+		image.Rect(0, 0, 10, 10),         // Inside a single cell.
+		image.Rect(10, 10, 40, 20),       // Spans several cells.
+		image.Rect(-20, -20, -5, -5),     // Negative coordinates.
+		image.Rect(-1000, 0, 1000, 1000), // Too many cells, stored in the large list.
+		image.Rect(16, 16, 32, 32),       // Exactly one cell, aligned to the grid.
+		image.Rect(5000, 5000, 5001, 5001),
+		image.Rect(-5000, -5000, 5000, -4000), // Too many cells, far away from everything else.
+	}
+
+	index := NewSpatialIndex(itemBounds, cellSize)
+	if want := []int{3, 6}; !slices.Equal(index.large, want) {
+		t.Fatalf("Got large items %v, want %v", index.large, want)
+	}
+
+	tests := []struct {
+		name string
+		rect image.Rectangle
+		want []int
+	}{
+		{name: "single cell", rect: image.Rect(0, 0, 1, 1), want: []int{0, 3}},
+		{name: "empty area", rect: image.Rect(100, -100, 200, -50), want: nil},
+		{name: "touching but not overlapping", rect: image.Rect(32, 32, 48, 48), want: []int{3}},
+		{name: "grid aligned cell", rect: image.Rect(16, 16, 32, 32), want: []int{1, 3, 4}},
+		{name: "negative coordinates", rect: image.Rect(-6, -6, -5, -5), want: []int{2}},
+		{name: "only large items", rect: image.Rect(-3000, -4500, 3000, -4400), want: []int{6}},
+		{name: "everything", rect: image.Rect(-10000, -10000, 10000, 10000), want: []int{0, 1, 2, 3, 4, 5, 6}},
+		{name: "empty rectangle", rect: image.Rectangle{}, want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := index.Query(tt.rect); !slices.Equal(got, tt.want) {
+				t.Errorf("Got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSpatialIndexQueryRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	itemBounds := make([]image.Rectangle, 500)
+	for i := range itemBounds {
+		x, y := rng.Intn(4000)-2000, rng.Intn(4000)-2000
+		size := rng.Intn(50) + 1
+		if i%50 == 0 {
+			size = rng.Intn(3000) + 1000 // Some items that end up in the large list.
+		}
+		itemBounds[i] = image.Rect(x, y, x+size, y+rng.Intn(size)+1)
+	}
+
+	index := NewSpatialIndex(itemBounds, 32)
+	if len(index.large) == 0 {
+		t.Fatalf("Expected some large items")
+	}
+
+	for n := 0; n < 200; n++ {
+		x, y := rng.Intn(5000)-2500, rng.Intn(5000)-2500
+		rect := image.Rect(x, y, x+rng.Intn(500), y+rng.Intn(500))
+
+		var want []int
+		for i, bounds := range itemBounds {
+			if bounds.Overlaps(rect) {
+				want = append(want, i)
+			}
+		}
+
+		if got := index.Query(rect); !slices.Equal(got, want) {
+			t.Fatalf("Query(%v) = %v, want %v", rect, got, want)
+		}
+	}
+}
@@ -650,7 +650,8 @@ end
 ---@param hp number
 ---@param maxHP number
 ---@param polymorphed boolean
-local function writePlayerPathEntry(file, pos, oldPos, hp, maxHP, polymorphed)
+---@param frame integer
+local function writePlayerPathEntry(file, pos, oldPos, hp, maxHP, polymorphed, frame)
 	if not file then return end
 
 	local struct = {
@@ -659,6 +660,7 @@ local function writePlayerPathEntry(file, pos, oldPos, hp, maxHP, polymorphed)
 		hp = hp,
 		maxHP = maxHP,
 		polymorphed = polymorphed,
+		frame = frame,
 	}
 
 	-- Some hacky way to generate valid JSON that doesn't break when the game crashes.
@@ -755,7 +757,7 @@ function Capture:StartCapturingPlayerPath(interval, outputPixelScale)
 				end
 				local polymorphed = playerEntity:HasTag("polymorphed")
 
-				if oldPos then writePlayerPathEntry(file, pos, oldPos, hp, maxHP, polymorphed) end
+				if oldPos then writePlayerPathEntry(file, pos, oldPos, hp, maxHP, polymorphed, GameGetFrameNum()) end
 				oldPos = pos
 			end
 
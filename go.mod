@@ -11,6 +11,7 @@ require (
 	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646
 	github.com/tdewolff/canvas v0.0.0-20231218015800-2ad5075e9362
 	golang.org/x/exp v0.0.0-20231219180239-dc181d75b848
+	golang.org/x/image v0.18.0
 )
 
 require (
@@ -35,7 +36,6 @@ require (
 	github.com/rivo/uniseg v0.4.4 // indirect
 	github.com/tdewolff/minify/v2 v2.20.10 // indirect
 	github.com/tdewolff/parse/v2 v2.7.7 // indirect
-	golang.org/x/image v0.18.0 // indirect
 	golang.org/x/net v0.23.0 // indirect
 	golang.org/x/sys v0.18.0 // indirect
 	golang.org/x/text v0.16.0 // indirect
@@ -3,7 +3,7 @@
 // This software is released under the MIT License.
 // https://opensource.org/licenses/MIT
 
-package main
+package stitch
 
 import (
 	"image"
@@ -46,9 +46,9 @@ func (b BlendMethodMedian) Draw(tiles []*ImageTile, destImage *image.RGBA) {
 			// Iterate through all images and create a list of colors.
 			for _, img := range images {
 				if img != nil {
-					if point.In(img.Bounds()) {
-						col := img.RGBAAt(point.X, point.Y)
-						rList, gList, bList = append(rList, col.R), append(gList, col.G), append(bList, col.B)
+					if point.In(img.Rect) {
+						pix := img.Pix[img.PixOffset(point.X, point.Y):]
+						rList, gList, bList = append(rList, pix[0]), append(gList, pix[1]), append(bList, pix[2])
 						count++
 						// Limit number of tiles to median blend.
 						// Will be ignored if the blend tile limit is 0.
@@ -68,17 +68,7 @@ func (b BlendMethodMedian) Draw(tiles []*ImageTile, destImage *image.RGBA) {
 				destImage.SetRGBA(ix, iy, color.RGBA{r, g, b, 255})
 
 			default: // Multiple overlapping tiles, median blend them.
-				var r, g, b uint8
-				switch count % 2 {
-				case 0: // Even.
-					r = uint8((int(QuickSelectUInt8(rList, count/2-1)) + int(QuickSelectUInt8(rList, count/2))) / 2)
-					g = uint8((int(QuickSelectUInt8(gList, count/2-1)) + int(QuickSelectUInt8(gList, count/2))) / 2)
-					b = uint8((int(QuickSelectUInt8(bList, count/2-1)) + int(QuickSelectUInt8(bList, count/2))) / 2)
-				default: // Odd.
-					r = QuickSelectUInt8(rList, count/2)
-					g = QuickSelectUInt8(gList, count/2)
-					b = QuickSelectUInt8(bList, count/2)
-				}
+				r, g, b := MedianUInt8(rList), MedianUInt8(gList), MedianUInt8(bList)
 				destImage.SetRGBA(ix, iy, color.RGBA{r, g, b, 255})
 			}
 		}
@@ -162,3 +152,17 @@ func (b BlendMethodFast) Draw(tiles []*ImageTile, destImage *image.RGBA) {
 		}
 	}
 }
+
+// BlendMethodTransparent ignores all tiles and makes the destination image fully transparent.
+// This is used to export overlays as separate layers.
+type BlendMethodTransparent struct{}
+
+// Draw implements the StitchedImageBlendMethod interface.
+func (b BlendMethodTransparent) Draw(tiles []*ImageTile, destImage *image.RGBA) {
+	draw.Draw(destImage, destImage.Bounds(), image.Transparent, image.Point{}, draw.Src)
+}
+
+// Opaque returns false, as the result of this blend method is transparent.
+func (b BlendMethodTransparent) Opaque() bool {
+	return false
+}
@@ -0,0 +1,209 @@
+// Copyright (c) 2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package stitch
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultCache is the cache that is used by all image tiles and stitched images.
+// Its budget can be changed at any time with SetBudget.
+var DefaultCache = NewCache(1 << 30)
+
+// Cache keeps decoded tile images and generated regions of stitched images in memory.
+// If the memory used by all entries exceeds the budget, the least recently used entries are evicted.
+//
+// Evicted entries are only dropped by their owners, anything that still references them will keep them alive until it's done.
+// So the real memory usage can temporarily exceed the budget a bit.
+type Cache struct {
+	mutex sync.Mutex
+
+	budget    int64 // Maximum number of bytes of all entries.
+	bytes     int64 // Number of bytes of all entries.
+	peakBytes int64
+
+	lru list.List // List of *cacheEntry, the front is the most recently used entry.
+
+	hits, misses, decodes int64
+	accessHits            atomic.Int64 // Hits that are counted by access, without taking the lock.
+}
+
+// cacheEntry is a single object that is managed by a cache, like the image of a tile.
+type cacheEntry struct {
+	element  *list.Element // Position in the LRU list, nil if the entry isn't cached.
+	size     int64         // Size in bytes.
+	evict    func()        // Called outside of the cache's lock when the entry got evicted. The owner has to drop its data, unless the entry got cached again.
+	accessed atomic.Bool   // Set when the entry has been accessed, without updating the LRU list.
+}
+
+// CacheStats contains statistics of a cache.
+type CacheStats struct {
+	Hits    int64 // Number of lookups that found their data in the cache.
+	Misses  int64 // Number of lookups that had to generate or decode their data.
+	Decodes int64 // Number of decoded tile images.
+
+	Bytes     int64 // Current size of all entries.
+	PeakBytes int64 // Maximum size of all entries so far.
+	Budget    int64
+}
+
+// HitRate returns the ratio of lookups that found their data in the cache.
+func (s CacheStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+func (s CacheStats) String() string {
+	return fmt.Sprintf("hit rate %.1f %%, %d decoded tiles, peak memory %d of %d MiB", s.HitRate()*100, s.Decodes, s.PeakBytes>>20, s.Budget>>20)
+}
+
+// NewCache returns a cache that keeps at most budget bytes.
+func NewCache(budget int64) *Cache {
+	return &Cache{budget: budget}
+}
+
+// SetBudget changes the maximum number of bytes of all entries, and evicts entries if needed.
+func (c *Cache) SetBudget(budget int64) {
+	c.mutex.Lock()
+	c.budget = budget
+	evicted := c.evict()
+	c.mutex.Unlock()
+
+	for _, entry := range evicted {
+		entry.evict()
+	}
+}
+
+// Stats returns the current statistics of the cache.
+func (c *Cache) Stats() CacheStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return CacheStats{
+		Hits:      c.hits + c.accessHits.Load(),
+		Misses:    c.misses,
+		Decodes:   c.decodes,
+		Bytes:     c.bytes,
+		PeakBytes: c.peakBytes,
+		Budget:    c.budget,
+	}
+}
+
+// put adds the entry with its newly generated data to the cache, and counts it as a miss.
+// The owner must not hold any lock that evict needs.
+func (c *Cache) put(entry *cacheEntry, size int64, decoded bool, evict func()) {
+	c.mutex.Lock()
+	c.misses++
+	if decoded {
+		c.decodes++
+	}
+	evicted := c.insert(entry, size, evict)
+	c.mutex.Unlock()
+
+	for _, entry := range evicted {
+		entry.evict()
+	}
+}
+
+// store adds the entry with its data to the cache, without counting it as a lookup.
+// The owner must not hold any lock that evict needs.
+func (c *Cache) store(entry *cacheEntry, size int64, evict func()) {
+	c.mutex.Lock()
+	evicted := c.insert(entry, size, evict)
+	c.mutex.Unlock()
+
+	for _, entry := range evicted {
+		entry.evict()
+	}
+}
+
+// insert adds or updates the entry, and evicts other entries if needed.
+//
+// The evict functions of the returned entries have to be called once the lock is released.
+func (c *Cache) insert(entry *cacheEntry, size int64, evict func()) []*cacheEntry {
+	if entry.element != nil {
+		c.bytes -= entry.size
+		c.lru.MoveToFront(entry.element)
+	} else {
+		entry.element = c.lru.PushFront(entry)
+	}
+	entry.size, entry.evict = size, evict
+	entry.accessed.Store(true) // Prevent the new entry from being evicted right away.
+
+	c.bytes += size
+	c.peakBytes = max(c.peakBytes, c.bytes)
+
+	return c.evict()
+}
+
+// hit counts a lookup that found the data of the entry, and marks the entry as recently used.
+func (c *Cache) hit(entry *cacheEntry) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.hits++
+	if entry.element != nil {
+		c.lru.MoveToFront(entry.element)
+	}
+}
+
+// access counts a lookup that found the data of the entry, and marks the entry as accessed.
+// Unlike hit, this doesn't take the lock or update the LRU list, so it's cheap enough to be called for every single pixel.
+func (c *Cache) access(entry *cacheEntry) {
+	c.accessHits.Add(1)
+	entry.accessed.Store(true)
+}
+
+// contains returns whether the entry is cached.
+func (c *Cache) contains(entry *cacheEntry) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return entry.element != nil
+}
+
+// remove removes the entry from the cache without calling its evict function.
+func (c *Cache) remove(entry *cacheEntry) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if entry.element != nil {
+		c.lru.Remove(entry.element)
+		entry.element = nil
+		c.bytes -= entry.size
+	}
+}
+
+// evict removes the least recently used entries until the cache fits into its budget.
+// Entries that have been accessed since they were last checked are moved to the front instead, this keeps frequently read entries without updating the list on every access.
+//
+// The evict functions of the returned entries have to be called once the lock is released.
+func (c *Cache) evict() []*cacheEntry {
+	var evicted []*cacheEntry
+
+	// Every entry gets at most one second chance, so this ends after two rounds.
+	for tries := 2 * c.lru.Len(); c.bytes > c.budget && c.lru.Len() > 1 && tries > 0; tries-- {
+		element := c.lru.Back()
+		entry := element.Value.(*cacheEntry)
+		if entry.accessed.Swap(false) {
+			c.lru.MoveToFront(element)
+			continue
+		}
+
+		c.lru.Remove(element)
+		entry.element = nil
+		c.bytes -= entry.size
+		evicted = append(evicted, entry)
+	}
+
+	return evicted
+}
@@ -0,0 +1,180 @@
+// Copyright (c) 2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package stitch
+
+import (
+	"slices"
+	"testing"
+)
+
+// testCacheEntries creates named cache entries, and records the names of evicted entries.
+type testCacheEntries struct {
+	entries map[string]*cacheEntry
+	evicted []string
+}
+
+func newTestCacheEntries(names ...string) *testCacheEntries {
+	e := &testCacheEntries{entries: map[string]*cacheEntry{}}
+	for _, name := range names {
+		e.entries[name] = &cacheEntry{}
+	}
+	return e
+}
+
+// put adds the entry with the given name to the cache as a generated entry.
+func (e *testCacheEntries) put(c *Cache, name string, size int64) {
+	c.put(e.entries[name], size, false, func() { e.evicted = append(e.evicted, name) })
+}
+
+// cached returns the names of all entries that are in the cache, sorted by name.
+func (e *testCacheEntries) cached(c *Cache) []string {
+	var names []string
+	for name, entry := range e.entries {
+		if c.contains(entry) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestCacheBudget(t *testing.T) {
+	c := NewCache(100)
+	e := newTestCacheEntries("a", "b", "c", "d")
+
+	e.put(c, "a", 40)
+	e.put(c, "b", 40)
+	if stats := c.Stats(); stats.Bytes != 80 || len(e.evicted) != 0 {
+		t.Fatalf("Got %d bytes and evicted %v, want 80 bytes and nothing evicted", stats.Bytes, e.evicted)
+	}
+
+	// Every entry got accessed when it was added, so the oldest one is evicted after all got their second chance.
+	e.put(c, "c", 40)
+	if stats := c.Stats(); stats.Bytes != 80 || !slices.Equal(e.evicted, []string{"a"}) {
+		t.Fatalf("Got %d bytes and evicted %v, want 80 bytes and a evicted", stats.Bytes, e.evicted)
+	}
+	if cached := e.cached(c); !slices.Equal(cached, []string{"b", "c"}) {
+		t.Errorf("Got cached entries %v, want [b c]", cached)
+	}
+
+	// Lowering the budget evicts right away.
+	c.SetBudget(50)
+	if stats := c.Stats(); stats.Bytes > 50 || len(e.evicted) != 2 {
+		t.Errorf("Got %d bytes and evicted %v after lowering the budget", stats.Bytes, e.evicted)
+	}
+
+	// A single entry is kept even if it exceeds the budget, as its owner is using it.
+	e.put(c, "d", 200)
+	if cached := e.cached(c); !slices.Equal(cached, []string{"d"}) {
+		t.Errorf("Got cached entries %v, want [d]", cached)
+	}
+	if stats := c.Stats(); stats.Bytes != 200 {
+		t.Errorf("Got %d bytes, want 200", stats.Bytes)
+	}
+}
+
+func TestCacheResize(t *testing.T) {
+	c := NewCache(100)
+	e := newTestCacheEntries("a")
+
+	// Adding an entry again updates its size instead of counting it twice.
+	e.put(c, "a", 40)
+	e.put(c, "a", 60)
+	if stats := c.Stats(); stats.Bytes != 60 {
+		t.Errorf("Got %d bytes, want 60", stats.Bytes)
+	}
+}
+
+func TestCacheSecondChance(t *testing.T) {
+	tests := []struct {
+		name        string
+		use         func(c *Cache, entry *cacheEntry)
+		wantEvicted []string
+	}{
+		{name: "unused", use: func(c *Cache, entry *cacheEntry) {}, wantEvicted: []string{"b"}},
+		{name: "accessed", use: (*Cache).access, wantEvicted: []string{"c"}},
+		{name: "hit", use: (*Cache).hit, wantEvicted: []string{"c"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCache(100)
+			e := newTestCacheEntries("a", "b", "c", "d", "e")
+
+			// After this, a is evicted, and all other entries have used up their second chance.
+			// The LRU list is d, c, b from front to back.
+			e.put(c, "a", 30)
+			e.put(c, "b", 30)
+			e.put(c, "c", 30)
+			e.put(c, "d", 30)
+			if !slices.Equal(e.evicted, []string{"a"}) {
+				t.Fatalf("Evicted %v, want [a]", e.evicted)
+			}
+			e.evicted = nil
+
+			// Without being used, b is the least recently used entry.
+			// An accessed entry gets a second chance, and a hit moves it to the front, both keep b.
+			tt.use(c, e.entries["b"])
+			e.put(c, "e", 30)
+			if !slices.Equal(e.evicted, tt.wantEvicted) {
+				t.Errorf("Evicted %v, want %v", e.evicted, tt.wantEvicted)
+			}
+		})
+	}
+}
+
+func TestCacheRemove(t *testing.T) {
+	c := NewCache(100)
+	e := newTestCacheEntries("a", "b", "c")
+
+	e.put(c, "a", 40)
+	e.put(c, "b", 40)
+	if !c.contains(e.entries["a"]) || c.contains(e.entries["c"]) {
+		t.Fatalf("Got cached entries %v, want [a b]", e.cached(c))
+	}
+
+	c.remove(e.entries["a"])
+	c.remove(e.entries["a"]) // Removing twice does nothing.
+	c.remove(e.entries["c"]) // Removing an entry that isn't cached does nothing.
+	if c.contains(e.entries["a"]) {
+		t.Errorf("Removed entry is still cached")
+	}
+	if len(e.evicted) != 0 {
+		t.Errorf("Removing called the evict functions of %v", e.evicted)
+	}
+	if stats := c.Stats(); stats.Bytes != 40 {
+		t.Errorf("Got %d bytes, want 40", stats.Bytes)
+	}
+
+	// The removed entry can be added again.
+	e.put(c, "a", 40)
+	if cached := e.cached(c); !slices.Equal(cached, []string{"a", "b"}) {
+		t.Errorf("Got cached entries %v, want [a b]", cached)
+	}
+}
+
+func TestCacheStats(t *testing.T) {
+	c := NewCache(100)
+	a, b, d := &cacheEntry{}, &cacheEntry{}, &cacheEntry{}
+
+	c.put(a, 60, true, func() {})  // Decoded miss.
+	c.put(b, 30, false, func() {}) // Generated miss.
+	c.hit(a)
+	c.access(b)
+	c.access(b)
+	c.store(d, 50, func() {}) // Neither a hit nor a miss, but it evicts a and b.
+
+	want := CacheStats{Hits: 3, Misses: 2, Decodes: 1, Bytes: 50, PeakBytes: 140, Budget: 100}
+	if stats := c.Stats(); stats != want {
+		t.Errorf("Got %#v, want %#v", stats, want)
+	}
+	if rate := want.HitRate(); rate != 0.6 {
+		t.Errorf("Got hit rate %v, want 0.6", rate)
+	}
+	if rate := (CacheStats{}).HitRate(); rate != 0 {
+		t.Errorf("Got hit rate %v without any lookups, want 0", rate)
+	}
+}
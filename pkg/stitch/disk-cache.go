@@ -0,0 +1,109 @@
+// Copyright (c) 2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package stitch
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultDiskCache is the disk cache that is used by all image tiles.
+// It is nil by default, which disables the disk cache.
+var DefaultDiskCache *DiskCache
+
+// DiskCache stores decoded tile images on disk in the QOI format, which is much faster to decode than PNG.
+// Repeated runs over the same tiles will then read the tiles from the disk cache, instead of decoding them again.
+//
+// Entries are identified by the path, modification time and size of the tile file, and by the scale divider.
+// Changed tiles will therefore just get new entries.
+// The cache is never cleaned up, but its directory can be deleted at any time.
+type DiskCache struct {
+	dir string
+
+	hits, misses atomic.Int64
+}
+
+// DiskCacheStats contains statistics of a disk cache.
+type DiskCacheStats struct {
+	Hits   int64 // Number of tiles that were read from the disk cache.
+	Misses int64 // Number of tiles that had to be decoded, and were then added to the disk cache.
+}
+
+func (s DiskCacheStats) String() string {
+	return fmt.Sprintf("%d of %d tiles read from disk cache", s.Hits, s.Hits+s.Misses)
+}
+
+// NewDiskCache returns a disk cache that stores its entries in dir.
+// The directory is created if it doesn't exist.
+func NewDiskCache(dir string) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create disk cache directory: %w", err)
+	}
+
+	return &DiskCache{dir: dir}, nil
+}
+
+// Stats returns the current statistics of the disk cache.
+func (c *DiskCache) Stats() DiskCacheStats {
+	return DiskCacheStats{
+		Hits:   c.hits.Load(),
+		Misses: c.misses.Load(),
+	}
+}
+
+// entryPath returns the path of the entry for the given tile file.
+func (c *DiskCache) entryPath(fileName string, modTime time.Time, fileSize int64, scaleDivider int) string {
+	if absPath, err := filepath.Abs(fileName); err == nil {
+		fileName = absPath
+	}
+
+	key := fmt.Sprintf("noita-mapcap disk cache v1\x00%s\x00%d\x00%d\x00%d", fileName, modTime.UnixNano(), fileSize, scaleDivider)
+	hash := sha256.Sum256([]byte(key))
+
+	return filepath.Join(c.dir, hex.EncodeToString(hash[:16])+".qoi")
+}
+
+// load returns the cached image at entryPath, or nil if there is no valid entry.
+// The image has its origin at (0, 0), and has to be of the given size.
+func (c *DiskCache) load(entryPath string, size image.Point) *image.RGBA {
+	data, err := os.ReadFile(entryPath)
+	if err != nil {
+		c.misses.Add(1)
+		return nil
+	}
+
+	img, err := decodeQOI(data, size)
+	if err != nil {
+		c.misses.Add(1)
+		return nil
+	}
+
+	c.hits.Add(1)
+	return img
+}
+
+// store writes img into the entry at entryPath.
+func (c *DiskCache) store(entryPath string, img *image.RGBA) error {
+	// Multiple processes may share the cache, so write the entry atomically.
+	f, err := createOutputFile(context.Background(), entryPath)
+	if err != nil {
+		return err
+	}
+	defer f.Discard()
+
+	if _, err := f.Write(encodeQOI(img)); err != nil {
+		return fmt.Errorf("failed to write disk cache entry %q: %w", entryPath, err)
+	}
+
+	return f.Commit()
+}
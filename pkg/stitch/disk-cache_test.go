@@ -0,0 +1,112 @@
+// Copyright (c) 2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package stitch
+
+import (
+	"bytes"
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDiskCacheEntryPath(t *testing.T) {
+	c, err := NewDiskCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	modTime := time.Date(2024, 5, 6, 7, 8, 9, 10, time.UTC)
+	base := c.entryPath("tiles/0,0.png", modTime, 1234, 1)
+
+	if path := c.entryPath("tiles/0,0.png", modTime, 1234, 1); path != base {
+		t.Errorf("Got %q for the same tile, want %q", path, base)
+	}
+	if absPath, err := filepath.Abs("tiles/0,0.png"); err != nil {
+		t.Fatal(err)
+	} else if path := c.entryPath(absPath, modTime, 1234, 1); path != base {
+		t.Errorf("Got %q for the absolute path of the same tile, want %q", path, base)
+	}
+
+	// Any change of the tile file or the scale has to result in a different entry, so the old one misses.
+	tests := []struct {
+		name         string
+		fileName     string
+		modTime      time.Time
+		fileSize     int64
+		scaleDivider int
+	}{
+		{name: "file name", fileName: "tiles/512,0.png", modTime: modTime, fileSize: 1234, scaleDivider: 1},
+		{name: "modification time", fileName: "tiles/0,0.png", modTime: modTime.Add(time.Nanosecond), fileSize: 1234, scaleDivider: 1},
+		{name: "file size", fileName: "tiles/0,0.png", modTime: modTime, fileSize: 1235, scaleDivider: 1},
+		{name: "scale divider", fileName: "tiles/0,0.png", modTime: modTime, fileSize: 1234, scaleDivider: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if path := c.entryPath(tt.fileName, tt.modTime, tt.fileSize, tt.scaleDivider); path == base {
+				t.Errorf("Got the same entry %q", path)
+			}
+		})
+	}
+}
+
+func TestDiskCacheLoad(t *testing.T) {
+	c, err := NewDiskCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img := newQOITestImage()
+	size := img.Rect.Size()
+	modTime := time.Date(2024, 5, 6, 7, 8, 9, 10, time.UTC)
+	entryPath := c.entryPath("tiles/0,0.png", modTime, 1234, 1)
+	if err := c.store(entryPath, img); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := c.load(entryPath, size)
+	if loaded == nil {
+		t.Fatalf("The stored entry wasn't loaded")
+	}
+	if loaded.Rect != image.Rect(0, 0, size.X, size.Y) {
+		t.Errorf("Got bounds %v, want the origin at (0, 0) and size %v", loaded.Rect, size)
+	}
+	for y := 0; y < size.Y; y++ {
+		row := img.Pix[img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y+y):][:size.X*4]
+		if !bytes.Equal(loaded.Pix[loaded.PixOffset(0, y):][:size.X*4], row) {
+			t.Fatalf("Row %d of the loaded entry differs", y)
+		}
+	}
+
+	// A changed tile file results in a different entry, which doesn't exist.
+	if loaded := c.load(c.entryPath("tiles/0,0.png", modTime.Add(time.Second), 1234, 1), size); loaded != nil {
+		t.Errorf("The entry of a changed tile was loaded")
+	}
+
+	// An entry of the wrong size is rejected, as it would be drawn at the wrong place.
+	if loaded := c.load(entryPath, size.Add(image.Pt(1, 0))); loaded != nil {
+		t.Errorf("An entry of the wrong size was loaded")
+	}
+
+	// A damaged entry is rejected.
+	damagedPath := filepath.Join(t.TempDir(), "damaged.qoi")
+	data, err := os.ReadFile(entryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(damagedPath, data[:len(data)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if loaded := c.load(damagedPath, size); loaded != nil {
+		t.Errorf("A damaged entry was loaded")
+	}
+
+	if stats, want := c.Stats(), (DiskCacheStats{Hits: 1, Misses: 3}); stats != want {
+		t.Errorf("Got %#v, want %#v", stats, want)
+	}
+}
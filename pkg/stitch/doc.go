@@ -0,0 +1,36 @@
+// Copyright (c) 2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+// Package stitch combines the screenshots captured by the noita-mapcap mod into one big image.
+//
+// The screenshots are loaded with LoadImageTiles, and combined into a StitchedImage with NewStitchedImage.
+// Decoded tiles can additionally be kept on disk between runs by setting DefaultDiskCache, which saves decoding the same PNG files again.
+// A StitchedImage implements image.Image, its pixels are generated on demand from the tiles by a StitchedImageBlendMethod.
+// Reading single pixels with At or RGBAAt is slow, use ReadRGBA to read whole rectangles at once.
+// Generated pixels are cached in full-width rows, which suits reading from top to bottom.
+// Consumers that read small rectangles in random order should use a SubStitchedImage with CacheLayoutBlocks, so that only the touched blocks are generated.
+// Additional content like markers or paths can be drawn on top of it by implementing StitchedImageOverlay.
+//
+// The result can be written with ExportPNG, ExportJPEG, ExportWebP or ExportDZI.
+// All exporters take an optional ProgressFunc that is regularly called with the progress of the export.
+// Big exports can be split into shards that are exported by separate processes or machines, see Shard.
+//
+// Tile loading, stitching and exporting stop when their context is cancelled.
+// Exporters write into temporary files that are only moved into place once they are complete, so an interrupted export doesn't leave truncated files behind.
+// A StitchedImage runs goroutines in the background, which are stopped by its Close method.
+//
+//	tiles, err := stitch.LoadImageTiles(ctx, "captures", 1)
+//	if err != nil {
+//		return err
+//	}
+//
+//	stitchedImage, err := stitch.NewStitchedImage(ctx, tiles, tiles.Bounds(), stitch.BlendMethodMedian{BlendTileLimit: 15}, 128, nil, 1)
+//	if err != nil {
+//		return err
+//	}
+//	defer stitchedImage.Close()
+//
+//	return stitch.ExportPNG(ctx, stitchedImage, "output.png", nil)
+package stitch
@@ -0,0 +1,231 @@
+// Copyright (c) 2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package stitch
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+)
+
+// dziJournalFileName is the name of the journal file inside the DZI tile directory.
+const dziJournalFileName = "export.journal"
+
+// dziTileKey identifies a single tile of a DZI.
+type dziTileKey struct {
+	ZoomLevel, X, Y int
+}
+
+// dziJournal records which tiles and zoom levels of a DZI export are finished, so that an interrupted export can be resumed.
+//
+// The journal is a text file.
+// The first line describes the settings of the export, the journal is only used if they match.
+// Every following line marks a tile (`tile <level> <x> <y>`) or a whole zoom level (`level <level>`) as finished.
+// Lines are only ever appended, and an incomplete last line is ignored and cut off before anything is appended.
+type dziJournal struct {
+	sync.Mutex
+
+	path string
+	file *os.File
+	size int64 // The size of the complete lines of the journal file when it was read.
+
+	finishedTiles  map[dziTileKey]struct{}
+	finishedLevels map[int]struct{}
+}
+
+// openDZIJournal opens the journal at path.
+// If the journal doesn't exist or was written with different settings, it is started from scratch.
+func openDZIJournal(path, settings string) (*dziJournal, error) {
+	j := newDZIJournal(path)
+
+	valid, err := j.read(settings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read journal %q: %w", path, err)
+	}
+
+	if valid {
+		// Cut off any incomplete last line, so that new lines are not appended to it.
+		if err := os.Truncate(path, j.size); err != nil {
+			return nil, fmt.Errorf("failed to truncate journal %q: %w", path, err)
+		}
+		j.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	} else {
+		j.file, err = os.Create(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal %q: %w", path, err)
+	}
+
+	if !valid {
+		if _, err := fmt.Fprintln(j.file, settings); err != nil {
+			j.file.Close()
+			return nil, fmt.Errorf("failed to write journal %q: %w", path, err)
+		}
+	}
+
+	return j, nil
+}
+
+// newDZIJournal returns an empty journal for the file at path, without reading or opening it.
+func newDZIJournal(path string) *dziJournal {
+	return &dziJournal{
+		path:           path,
+		finishedTiles:  map[dziTileKey]struct{}{},
+		finishedLevels: map[int]struct{}{},
+	}
+}
+
+// readDZIJournal reads the journal at path without opening it for writing.
+// It returns an error if the journal doesn't exist, or if it was written with different settings.
+func readDZIJournal(path, settings string) (*dziJournal, error) {
+	j := newDZIJournal(path)
+
+	valid, err := j.read(settings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read journal %q: %w", path, err)
+	}
+	if !valid {
+		return nil, fmt.Errorf("journal %q doesn't exist or was written with different settings", path)
+	}
+
+	return j, nil
+}
+
+// read loads the finished tiles and levels from the journal file.
+// It returns false if there is no journal, or if it was written with different settings.
+func (j *dziJournal) read(settings string) (bool, error) {
+	f, err := os.Open(j.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	settingsRead := false
+	for {
+		// Only complete lines are used.
+		// An incomplete last line may be cut off anywhere, like in the middle of a number.
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return false, err
+		}
+		j.size += int64(len(line))
+		line = strings.TrimSuffix(line, "\n")
+
+		if !settingsRead {
+			if line != settings {
+				return false, nil
+			}
+			settingsRead = true
+			continue
+		}
+
+		var key dziTileKey
+		switch {
+		case strings.HasPrefix(line, "tile "):
+			if n, _ := fmt.Sscanf(line, "tile %d %d %d", &key.ZoomLevel, &key.X, &key.Y); n == 3 {
+				j.finishedTiles[key] = struct{}{}
+			}
+		case strings.HasPrefix(line, "level "):
+			if n, _ := fmt.Sscanf(line, "level %d", &key.ZoomLevel); n == 1 {
+				j.finishedLevels[key.ZoomLevel] = struct{}{}
+			}
+		}
+	}
+
+	return settingsRead, nil
+}
+
+// FinishedTiles returns the number of finished tiles.
+func (j *dziJournal) FinishedTiles() int {
+	j.Lock()
+	defer j.Unlock()
+
+	return len(j.finishedTiles)
+}
+
+// TileFinished returns whether the given tile has been marked as finished.
+func (j *dziJournal) TileFinished(key dziTileKey) bool {
+	j.Lock()
+	defer j.Unlock()
+
+	_, ok := j.finishedTiles[key]
+	return ok
+}
+
+// LevelFinished returns whether the given zoom level has been marked as finished.
+func (j *dziJournal) LevelFinished(zoomLevel int) bool {
+	j.Lock()
+	defer j.Unlock()
+
+	_, ok := j.finishedLevels[zoomLevel]
+	return ok
+}
+
+// FinishTile marks the given tile as finished.
+// This must only be called once the tile has been written completely.
+func (j *dziJournal) FinishTile(key dziTileKey) error {
+	j.Lock()
+	defer j.Unlock()
+
+	if _, err := fmt.Fprintf(j.file, "tile %d %d %d\n", key.ZoomLevel, key.X, key.Y); err != nil {
+		return fmt.Errorf("failed to write journal %q: %w", j.path, err)
+	}
+	j.finishedTiles[key] = struct{}{}
+
+	return nil
+}
+
+// FinishLevel marks the given zoom level as finished.
+// This must only be called once all tiles of the level have been written completely.
+func (j *dziJournal) FinishLevel(zoomLevel int) error {
+	j.Lock()
+	defer j.Unlock()
+
+	if _, err := fmt.Fprintf(j.file, "level %d\n", zoomLevel); err != nil {
+		return fmt.Errorf("failed to write journal %q: %w", j.path, err)
+	}
+	j.finishedLevels[zoomLevel] = struct{}{}
+
+	return nil
+}
+
+// Tiles returns the keys of all finished tiles.
+func (j *dziJournal) Tiles() []dziTileKey {
+	j.Lock()
+	defer j.Unlock()
+
+	keys := make([]dziTileKey, 0, len(j.finishedTiles))
+	for key := range j.finishedTiles {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// Close closes the journal file, but keeps it on disk.
+func (j *dziJournal) Close() error {
+	if j.file == nil {
+		return nil
+	}
+	return j.file.Close()
+}
+
+// Remove closes and deletes the journal file.
+// This is done once the export is complete.
+func (j *dziJournal) Remove() error {
+	j.Close()
+	return os.Remove(j.path)
+}
@@ -0,0 +1,503 @@
+// Copyright (c) 2023-2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package stitch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type DZI struct {
+	stitchedImage *StitchedImage
+
+	fileExtension string
+
+	tileSize int // The (maximum) width and height of a tile in pixels, not including the overlap.
+	overlap  int // The amount of additional pixels on every side of every tile. The real (max) width/height of an image is `2*overlap + tileSize`.
+
+	maxZoomLevel int // The maximum zoom level that is needed.
+}
+
+// NewDZI creates a new DZI from the given StitchedImages.
+//
+// dziTileSize and dziOverlap define the size and overlap of the resulting DZI tiles.
+func NewDZI(stitchedImage *StitchedImage, dziTileSize, dziOverlap int) DZI {
+	dzi := DZI{
+		stitchedImage: stitchedImage,
+
+		fileExtension: ".webp",
+
+		overlap:  dziOverlap,
+		tileSize: dziTileSize,
+	}
+
+	width, height := stitchedImage.bounds.Dx(), stitchedImage.bounds.Dy()
+
+	// Calculate max zoom level and stuff.
+	neededLength := max(width, height)
+	var sideLength int = 1
+	var level int
+	for sideLength < neededLength {
+		level += 1
+		sideLength *= 2
+	}
+	dzi.maxZoomLevel = level
+	//dzi.maxZoomLevelLength = sideLength
+
+	return dzi
+}
+
+// Bounds returns the bounds of the highest zoom level in output coordinates.
+func (d DZI) Bounds() image.Rectangle {
+	return d.stitchedImage.bounds
+}
+
+// ScaleDivider returns the scale divider of the highest zoom level.
+func (d DZI) ScaleDivider() int {
+	return d.stitchedImage.scaleDivider
+}
+
+// TileSize returns the (maximum) width and height of a tile in pixels, not including the overlap.
+func (d DZI) TileSize() int {
+	return d.tileSize
+}
+
+// Overlap returns the amount of additional pixels on every side of every tile.
+func (d DZI) Overlap() int {
+	return d.overlap
+}
+
+// FileExtension returns the file extension of the tiles, including the leading dot.
+func (d DZI) FileExtension() string {
+	return d.fileExtension
+}
+
+// ExportDZIDescriptor exports the descriptive JSON file at the given path.
+func (d DZI) ExportDZIDescriptor(ctx context.Context, outputPath string) error {
+	log.Printf("Creating DZI descriptor %q.", outputPath)
+
+	f, err := createOutputFile(ctx, outputPath)
+	if err != nil {
+		return err
+	}
+	defer f.Discard()
+
+	// Prepare data that describes the layout of the image files.
+	var dziDescriptor struct {
+		Image struct {
+			XMLNS    string `json:"xmlns"`
+			Format   string
+			Overlap  string
+			TileSize string
+			Size     struct {
+				Width  string
+				Height string
+			}
+			TopLeft struct {
+				X string
+				Y string
+			}
+		}
+	}
+
+	dziDescriptor.Image.XMLNS = "http://schemas.microsoft.com/deepzoom/2008"
+	dziDescriptor.Image.Format = "webp"
+	dziDescriptor.Image.Overlap = strconv.Itoa(d.overlap)
+	dziDescriptor.Image.TileSize = strconv.Itoa(d.tileSize)
+	dziDescriptor.Image.Size.Width = strconv.Itoa(d.stitchedImage.bounds.Dx())
+	dziDescriptor.Image.Size.Height = strconv.Itoa(d.stitchedImage.bounds.Dy())
+	dziDescriptor.Image.TopLeft.X = strconv.Itoa(d.stitchedImage.bounds.Min.X)
+	dziDescriptor.Image.TopLeft.Y = strconv.Itoa(d.stitchedImage.bounds.Min.Y)
+
+	jsonEnc := json.NewEncoder(f)
+	if err := jsonEnc.Encode(dziDescriptor); err != nil {
+		return fmt.Errorf("failed to encode DZI descriptor: %w", err)
+	}
+
+	return f.Commit()
+}
+
+// ExportDZITiles exports the single image tiles for every zoom level.
+// The progress is reported in tiles.
+//
+// Every tile is written completely or not at all, but if ctx is cancelled there will be tiles missing.
+// The finished tiles are recorded in a journal inside outputDir.
+// When the export is run again with the same settings, these tiles are skipped, so an interrupted export can be resumed.
+// The journal can't detect changes of the source tiles or the overlay data, in that case outputDir has to be removed first.
+func (d DZI) ExportDZITiles(ctx context.Context, outputDir string, webPLevel int, progress ProgressFunc) error {
+	log.Printf("Creating DZI tiles in %q.", outputDir)
+
+	return d.exportDZITiles(ctx, outputDir, Shard{}, webPLevel, progress)
+}
+
+// ExportDZIShard exports the tiles of the highest zoom level that belong to the given shard.
+// The progress is reported in tiles.
+//
+// All shards of an export can write into the same directory, or into different directories whose contents are copied together afterwards.
+// Once all shards are finished, MergeDZIShards generates the remaining zoom levels.
+//
+// Every shard has its own journal inside outputDir, so an interrupted shard can be resumed.
+// When the shards write into different directories, the journals (`export.shard-*.journal`) and the `overlay-free` directory have to be copied together with the tiles.
+// MergeDZIShards needs the journals, and it generates the lower zoom levels from the overlay free tiles, otherwise it has to export them again from the source images.
+func (d DZI) ExportDZIShard(ctx context.Context, outputDir string, shard Shard, webPLevel int, progress ProgressFunc) error {
+	if err := shard.validate(); err != nil {
+		return err
+	}
+
+	log.Printf("Creating DZI shard %v in %q.", shard, outputDir)
+
+	return d.exportDZITiles(ctx, outputDir, shard, webPLevel, progress)
+}
+
+// MergeDZIShards takes the tiles that all shardCount shards exported into outputDir, and generates the remaining zoom levels from them.
+// The progress is reported in tiles.
+//
+// An error is returned if any shard isn't finished, or if it was exported with different settings.
+// Any tile that is missing in outputDir is exported again.
+func (d DZI) MergeDZIShards(ctx context.Context, outputDir string, shardCount int, webPLevel int, progress ProgressFunc) error {
+	log.Printf("Merging %d DZI shards in %q.", shardCount, outputDir)
+
+	settings := d.journalSettings()
+	var shardJournals []*dziJournal
+	for i := 0; i < shardCount; i++ {
+		shard := Shard{Index: i, Count: shardCount}
+		shardJournal, err := readDZIJournal(filepath.Join(outputDir, shard.journalFileName()), settings)
+		if err != nil {
+			return fmt.Errorf("DZI shard %v can't be merged: %w", shard, err)
+		}
+		if !shardJournal.LevelFinished(d.maxZoomLevel) {
+			return fmt.Errorf("DZI shard %v isn't finished", shard)
+		}
+		shardJournals = append(shardJournals, shardJournal)
+	}
+
+	// Record the tiles of all shards in the journal of the export, so they are not exported again.
+	journal, err := openDZIJournal(filepath.Join(outputDir, dziJournalFileName), settings)
+	if err != nil {
+		return err
+	}
+	for _, shardJournal := range shardJournals {
+		for _, key := range shardJournal.Tiles() {
+			if !journal.TileFinished(key) {
+				if err := journal.FinishTile(key); err != nil {
+					journal.Close()
+					return err
+				}
+			}
+		}
+	}
+	if err := journal.Close(); err != nil {
+		return fmt.Errorf("failed to close journal: %w", err)
+	}
+
+	if err := d.exportDZITiles(ctx, outputDir, Shard{}, webPLevel, progress); err != nil {
+		return err
+	}
+
+	for _, shardJournal := range shardJournals {
+		if err := shardJournal.Remove(); err != nil {
+			log.Printf("Failed to remove shard journal: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// exportDZITiles exports the tiles of all zoom levels.
+// If shard is set, only the tiles of the highest zoom level that belong to the shard are exported.
+func (d DZI) exportDZITiles(ctx context.Context, outputDir string, shard Shard, webPLevel int, progress ProgressFunc) error {
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	sharded := shard.Count > 0
+
+	journalPath := filepath.Join(outputDir, dziJournalFileName)
+	minZoomLevel := 0
+	if sharded {
+		journalPath = filepath.Join(outputDir, shard.journalFileName())
+		minZoomLevel = d.maxZoomLevel
+	}
+
+	journal, err := openDZIJournal(journalPath, d.journalSettings())
+	if err != nil {
+		return err
+	}
+	defer journal.Close()
+	if finished := journal.FinishedTiles(); finished > 0 {
+		log.Printf("Resuming DZI export, %d tiles are already finished.", finished)
+	}
+
+	// Once a tile failed to export, the tiles of lower zoom levels that are generated from it are not recorded in the journal.
+	// This way they are exported again on the next run.
+	var failedTiles atomic.Int64
+
+	const scaleDivider = 2
+
+	// Count final number of tiles, the progress is based on the number of exported tiles.
+	bounds := d.stitchedImage.bounds
+	var finalTiles int64
+	for zoomLevel := d.maxZoomLevel; zoomLevel >= minZoomLevel; zoomLevel-- {
+		rows := (bounds.Dy()-1)/d.tileSize + 1
+		if sharded {
+			start, end := shard.rows(rows)
+			rows = end - start
+		}
+		finalTiles += int64(rows * ((bounds.Dx()-1)/d.tileSize + 1))
+		bounds = image.Rect(DivideFloor(bounds.Min.X, scaleDivider), DivideFloor(bounds.Min.Y, scaleDivider), DivideCeil(bounds.Max.X, scaleDivider), DivideCeil(bounds.Max.Y, scaleDivider))
+	}
+
+	var exportedTiles atomic.Int64
+	stop := trackProgress(progress, func() (int64, int64) { return exportedTiles.Load(), finalTiles })
+	defer stop()
+
+	// Start with the highest zoom level (Where every world pixel is exactly mapped into one image pixel).
+	// Generate all tiles for this level, and then stitch another image (scaled down by a factor of 2) based on the previously generated tiles.
+	// Repeat this process until we have generated level 0.
+
+	// The current stitched image we are working with.
+	stitchedImage := d.stitchedImage
+
+	// Any other stitched image is created here, so it has to be closed here.
+	defer func() {
+		if stitchedImage != d.stitchedImage {
+			stitchedImage.Close()
+		}
+	}()
+
+	// Overlays are drawn separately for every zoom level.
+	// This keeps their size constant and prevents them from getting blurry on lower zoom levels.
+	// Therefore the lower zoom levels have to be generated from overlay free tiles, which are stored in a temporary directory.
+	// This directory is kept until the export is complete, as the overlay free tiles are needed to resume the export.
+	overlays := d.stitchedImage.overlays
+
+	// Overlay layers are drawn onto a transparent background, which stays transparent on every zoom level.
+	// So there is no need to store and read back any overlay free tiles.
+	_, transparent := d.stitchedImage.blendMethod.(BlendMethodTransparent)
+
+	var cleanDir string
+	if len(overlays) > 0 {
+		si := d.stitchedImage
+		if stitchedImage, err = NewStitchedImage(ctx, si.tiles, si.bounds, si.blendMethod, si.cacheRowHeight, nil, si.scaleDivider); err != nil {
+			return fmt.Errorf("failed to run NewStitchedImage(): %w", err)
+		}
+
+		if !transparent {
+			cleanDir = filepath.Join(outputDir, "overlay-free")
+		}
+	}
+
+	// The directory of the overlay free tiles of the previous zoom level.
+	var prevCleanLevelPath string
+
+	for zoomLevel := d.maxZoomLevel; zoomLevel >= minZoomLevel; zoomLevel-- {
+		// The overlay free tiles of this level are only needed if the next level isn't finished yet.
+		needsSource := cleanDir == "" || !journal.LevelFinished(zoomLevel-1)
+		failedBefore := failedTiles.Load()
+
+		levelBasePath := filepath.Join(outputDir, fmt.Sprintf("%d", zoomLevel))
+		if err := os.MkdirAll(levelBasePath, 0755); err != nil {
+			return fmt.Errorf("failed to create zoom level base directory %q: %w", levelBasePath, err)
+		}
+
+		var cleanLevelPath string
+		if cleanDir != "" {
+			cleanLevelPath = filepath.Join(cleanDir, fmt.Sprintf("%d", zoomLevel))
+			if err := os.MkdirAll(cleanLevelPath, 0755); err != nil {
+				return fmt.Errorf("failed to create temporary zoom level directory %q: %w", cleanLevelPath, err)
+			}
+		}
+
+		// Overlays keep the sizes of the highest zoom level.
+		overlayScale := OverlayScale{Divider: stitchedImage.scaleDivider, SizeDivider: d.stitchedImage.scaleDivider}
+
+		// Export tiles.
+		// Shards only export their rows of the highest zoom level.
+		startRow, endRow := 0, (stitchedImage.bounds.Dy()-1)/d.tileSize+1
+		if sharded {
+			startRow, endRow = shard.rows(endRow)
+		}
+		columns := (stitchedImage.bounds.Dx()-1)/d.tileSize + 1
+
+		// Store list of tiles, so that we can reuse them in the next step for the smaller zoom level.
+		// The exported tiles are passed to their image tile while the list is filled, so it must not be reallocated.
+		imageTiles := make(ImageTiles, 0, (endRow-startRow)*columns)
+
+		lg := NewLimitGroup(runtime.NumCPU())
+		for iY := startRow; iY < endRow && ctx.Err() == nil; iY++ {
+			for iX := 0; iX < columns && ctx.Err() == nil; iX++ {
+				rect := image.Rect(iX*d.tileSize, iY*d.tileSize, iX*d.tileSize+d.tileSize, iY*d.tileSize+d.tileSize)
+				rect = rect.Add(stitchedImage.bounds.Min)
+				rect = rect.Inset(-d.overlap)
+				img := stitchedImage.SubStitchedImage(rect).WithCacheLayout(CacheLayoutBlocks) // Only generate the parts of the image that this tile touches.
+				fileName := fmt.Sprintf("%d_%d%s", iX, iY, d.fileExtension)
+				filePath := filepath.Join(levelBasePath, fileName)
+
+				// The file the next zoom level is generated from.
+				sourceFilePath := filePath
+				if cleanLevelPath != "" {
+					sourceFilePath = filepath.Join(cleanLevelPath, fileName)
+				}
+
+				imageTiles = append(imageTiles, ImageTile{
+					fileName:     sourceFilePath,
+					modTime:      time.Now(),
+					scaleDivider: scaleDivider,
+					image:        image.Rect(DivideFloor(img.Bounds().Min.X, scaleDivider), DivideFloor(img.Bounds().Min.Y, scaleDivider), DivideCeil(img.Bounds().Max.X, scaleDivider), DivideCeil(img.Bounds().Max.Y, scaleDivider)),
+					imageMutex:   &sync.RWMutex{},
+					cacheEntry:   &cacheEntry{},
+				})
+
+				// The image tile of the next zoom level, which gets the exported tile without decoding it again.
+				// Shards don't export the next zoom level, and transparent images don't read any tiles.
+				var sourceTile *ImageTile
+				if !sharded && !transparent {
+					sourceTile = &imageTiles[len(imageTiles)-1]
+				}
+
+				key := dziTileKey{ZoomLevel: zoomLevel, X: iX, Y: iY}
+				if journal.TileFinished(key) && fileExists(filePath) && (!needsSource || fileExists(sourceFilePath)) {
+					exportedTiles.Add(1)
+				} else {
+					lg.Add(1)
+					go func() {
+						defer lg.Done()
+						if err := exportDZITile(ctx, img, filePath, sourceFilePath, sourceTile, overlays, overlayScale, webPLevel); err != nil {
+							if ctx.Err() == nil {
+								log.Printf("Failed to export DZI tile: %v", err)
+								failedTiles.Add(1)
+							}
+						} else if failedBefore == 0 {
+							if err := journal.FinishTile(key); err != nil {
+								log.Printf("Failed to record DZI tile: %v", err)
+							}
+						}
+						exportedTiles.Add(1)
+					}()
+				}
+			}
+		}
+		lg.Wait()
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if failedTiles.Load() == 0 {
+			if err := journal.FinishLevel(zoomLevel); err != nil {
+				return err
+			}
+		}
+
+		// The tiles and the journal of a shard are kept for MergeDZIShards.
+		if sharded {
+			break
+		}
+
+		// The overlay free tiles of the previous zoom level are not needed anymore.
+		if prevCleanLevelPath != "" {
+			if err := os.RemoveAll(prevCleanLevelPath); err != nil {
+				log.Printf("Failed to remove temporary directory %q: %v", prevCleanLevelPath, err)
+			}
+		}
+		prevCleanLevelPath = cleanLevelPath
+
+		// Create new stitched image from the previously exported tiles.
+		// The tiles are already created in a way, that they are scaled down by a factor of 2.
+		// Transparent images don't need any tiles, they only need the same bounds.
+		var nextStitchedImage *StitchedImage
+		if transparent {
+			nextStitchedImage, err = NewStitchedImage(ctx, nil, imageTiles.Bounds(), BlendMethodTransparent{}, 128, nil, stitchedImage.scaleDivider*scaleDivider)
+		} else {
+			nextStitchedImage, err = NewStitchedImage(ctx, imageTiles, imageTiles.Bounds(), BlendMethodFast{}, 128, nil, stitchedImage.scaleDivider*scaleDivider)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to run NewStitchedImage(): %w", err)
+		}
+		if stitchedImage != d.stitchedImage {
+			stitchedImage.Close()
+		}
+		stitchedImage = nextStitchedImage
+	}
+
+	if failed := failedTiles.Load(); failed > 0 {
+		return fmt.Errorf("failed to export %d tiles", failed)
+	}
+
+	if sharded {
+		return nil
+	}
+
+	// The export is complete, so there is nothing left to resume.
+	if cleanDir != "" {
+		if err := os.RemoveAll(cleanDir); err != nil {
+			log.Printf("Failed to remove temporary directory %q: %v", cleanDir, err)
+		}
+	}
+	if err := journal.Remove(); err != nil {
+		log.Printf("Failed to remove journal: %v", err)
+	}
+
+	return nil
+}
+
+// journalSettings returns a description of all settings that affect the resulting tiles.
+// The export journal is only used if this matches.
+func (d DZI) journalSettings() string {
+	si := d.stitchedImage
+
+	return fmt.Sprintf("noita-mapcap DZI journal v1: bounds %v, scale divider %d, tile size %d, overlap %d, format %s, blend method %#v, overlays [%s]",
+		si.bounds, si.scaleDivider, d.tileSize, d.overlap, d.fileExtension, si.blendMethod, overlayTypes(si.overlays))
+}
+
+// exportDZITile exports a single DZI tile to filePath.
+//
+// If there are any overlays, the overlay free image is additionally written to sourceFilePath, and the overlays are drawn into the tile at filePath.
+// If filePath and sourceFilePath are the same, the overlay free image is not written.
+//
+// Once the overlay free image is written, it's passed to sourceTile, if set.
+func exportDZITile(ctx context.Context, img SubStitchedImage, filePath, sourceFilePath string, sourceTile *ImageTile, overlays []StitchedImageOverlay, overlayScale OverlayScale, webPLevel int) error {
+	imgRGBA := image.NewRGBA(img.Bounds())
+	img.ReadRGBA(imgRGBA)
+
+	if len(overlays) == 0 {
+		if err := writeWebP(ctx, imgRGBA, filePath, webPLevel); err != nil {
+			return err
+		}
+		if sourceTile != nil {
+			sourceTile.setImage(imgRGBA)
+		}
+		return nil
+	}
+
+	// The overlay free tile is only used to generate the next zoom level, so use the fastest compression.
+	if sourceFilePath != filePath {
+		if err := writeWebP(ctx, imgRGBA, sourceFilePath, 0); err != nil {
+			return err
+		}
+		if sourceTile != nil {
+			sourceTile.setImage(imgRGBA)
+		}
+	}
+
+	for _, overlay := range overlays {
+		if overlay != nil {
+			overlay.Draw(imgRGBA, overlayScale)
+		}
+	}
+
+	return writeWebP(ctx, imgRGBA, filePath, webPLevel)
+}
@@ -0,0 +1,316 @@
+// Copyright (c) 2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package stitch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+// writeTestTiles writes a few overlapping PNG tiles into dir, and returns dir.
+// Overlapping tiles have slightly different pixels, so the result depends on the blend method.
+func writeTestTiles(t *testing.T, dir string) string {
+	t.Helper()
+
+	const width, height, step = 100, 80, 90
+	for tY := 0; tY < 2; tY++ {
+		for tX := 0; tX < 3; tX++ {
+			x, y := tX*step-50, tY*(height-10)-20
+			img := image.NewRGBA(image.Rect(0, 0, width, height))
+			for iY := 0; iY < height; iY++ {
+				for iX := 0; iX < width; iX++ {
+					wX, wY := x+iX, y+iY
+					img.SetRGBA(iX, iY, color.RGBA{uint8(wX * 3), uint8(wY * 5), uint8(wX ^ wY + (tX+tY)*16), 255})
+				}
+			}
+
+			var buf bytes.Buffer
+			if err := png.Encode(&buf, img); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("%d,%d.png", x, y)), buf.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	return dir
+}
+
+// newTestStitchedImage returns a stitched image of the tiles in tileDir.
+// The stitched image is closed when the test finishes.
+func newTestStitchedImage(t *testing.T, ctx context.Context, tileDir string, blendMethod StitchedImageBlendMethod, overlays []StitchedImageOverlay) *StitchedImage {
+	t.Helper()
+
+	tiles, err := LoadImageTiles(context.Background(), tileDir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stitchedImage, err := NewStitchedImage(ctx, tiles, tiles.Bounds(), blendMethod, 32, overlays, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { stitchedImage.Close() })
+
+	return stitchedImage
+}
+
+// testOverlay draws a rectangle and a line of single pixels.
+// The rectangle keeps its size on every zoom level, and the line is always one pixel wide.
+type testOverlay struct{}
+
+func (testOverlay) Draw(destImage *image.RGBA, scale OverlayScale) {
+	size := 20 / scale.SizeDivider
+	x, y := DivideFloor(30, scale.Divider), DivideFloor(10, scale.Divider)
+	rect := image.Rect(x, y, x+size, y+size).Intersect(destImage.Bounds())
+	for iY := rect.Min.Y; iY < rect.Max.Y; iY++ {
+		for iX := rect.Min.X; iX < rect.Max.X; iX++ {
+			destImage.SetRGBA(iX, iY, color.RGBA{255, 0, 0, 255})
+		}
+	}
+
+	for iX := destImage.Rect.Min.X; iX < destImage.Rect.Max.X; iX++ {
+		if p := image.Pt(iX, DivideFloor(50, scale.Divider)); p.In(destImage.Rect) {
+			destImage.SetRGBA(p.X, p.Y, color.RGBA{0, 0, 255, 255})
+		}
+	}
+}
+
+// countdownContext is a context that cancels itself once Err has been called a given number of times.
+// This interrupts an export at a reproducible point.
+type countdownContext struct {
+	context.Context
+	cancel    context.CancelFunc
+	remaining atomic.Int64
+}
+
+func newCountdownContext(calls int64) *countdownContext {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &countdownContext{Context: ctx, cancel: cancel}
+	c.remaining.Store(calls)
+	return c
+}
+
+func (c *countdownContext) Err() error {
+	if c.remaining.Add(-1) < 0 {
+		c.cancel()
+	}
+	return c.Context.Err()
+}
+
+// readDirFiles returns the contents of all files in dir and its subdirectories, keyed by their path relative to dir.
+func readDirFiles(t *testing.T, dir string) map[string][]byte {
+	t.Helper()
+
+	files := map[string][]byte{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(relPath)] = data
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return files
+}
+
+// compareDirs fails the test if the files in dir differ from the ones in wantDir.
+func compareDirs(t *testing.T, wantDir, dir string) {
+	t.Helper()
+
+	want, got := readDirFiles(t, wantDir), readDirFiles(t, dir)
+	for path, wantData := range want {
+		if data, ok := got[path]; !ok {
+			t.Errorf("File %q is missing", path)
+		} else if !bytes.Equal(data, wantData) {
+			t.Errorf("File %q differs", path)
+		}
+	}
+	for path := range got {
+		if _, ok := want[path]; !ok {
+			t.Errorf("Unexpected file %q", path)
+		}
+	}
+}
+
+func TestExportDZIResume(t *testing.T) {
+	tileDir := writeTestTiles(t, t.TempDir())
+
+	tests := []struct {
+		name        string
+		blendMethod StitchedImageBlendMethod
+		overlays    []StitchedImageOverlay
+	}{
+		{name: "without overlays", blendMethod: BlendMethodMedian{}},
+		{name: "with overlays", blendMethod: BlendMethodMedian{}, overlays: []StitchedImageOverlay{testOverlay{}}},
+		{name: "overlay layer", blendMethod: BlendMethodTransparent{}, overlays: []StitchedImageOverlay{testOverlay{}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			export := func(ctx context.Context, outputPath string) error {
+				stitchedImage := newTestStitchedImage(t, ctx, tileDir, tt.blendMethod, tt.overlays)
+				return ExportDZI(ctx, stitchedImage, outputPath, 64, 2, 0, nil)
+			}
+
+			wantDir := t.TempDir()
+			if err := export(context.Background(), filepath.Join(wantDir, "output.dzi")); err != nil {
+				t.Fatalf("Uninterrupted export failed: %v", err)
+			}
+
+			settings := NewDZI(newTestStitchedImage(t, context.Background(), tileDir, tt.blendMethod, tt.overlays), 64, 2).journalSettings()
+
+			// Interrupt the export at more and more advanced points, until it runs through.
+			interruptedLevels := map[int]bool{}
+			for calls := int64(1); ; calls = calls*3/2 + 1 {
+				dir := t.TempDir()
+				outputPath := filepath.Join(dir, "output.dzi")
+
+				err := export(newCountdownContext(calls), outputPath)
+				if err == nil {
+					break
+				}
+				if !errors.Is(err, context.Canceled) {
+					t.Fatalf("Interrupted export after %d calls failed: %v", calls, err)
+				}
+				if fileExists(outputPath) {
+					t.Fatalf("Interrupted export after %d calls wrote the descriptor", calls)
+				}
+
+				// Remember the number of finished levels, to check that the export got interrupted on different levels.
+				if journal, err := readDZIJournal(filepath.Join(dir, "output_files", dziJournalFileName), settings); err == nil {
+					interruptedLevels[len(journal.finishedLevels)] = true
+				}
+
+				if err := export(context.Background(), outputPath); err != nil {
+					t.Fatalf("Resumed export after %d calls failed: %v", calls, err)
+				}
+				compareDirs(t, wantDir, dir)
+			}
+
+			if len(interruptedLevels) < 3 {
+				t.Errorf("The export got only interrupted with %v finished levels", interruptedLevels)
+			}
+		})
+	}
+}
+
+func TestDZIJournalRead(t *testing.T) {
+	const settings = "test settings"
+
+	tests := []struct {
+		name       string
+		content    string
+		wantValid  bool
+		wantTiles  []dziTileKey
+		wantLevels []int
+		wantSize   int64
+	}{
+		{name: "complete", content: settings + "\ntile 1 2 3\nlevel 4\n", wantValid: true,
+			wantTiles: []dziTileKey{{1, 2, 3}}, wantLevels: []int{4}, wantSize: 33},
+		{name: "torn tile line", content: settings + "\ntile 1 2 3\ntile 5 6 1", wantValid: true,
+			wantTiles: []dziTileKey{{1, 2, 3}}, wantSize: 25},
+		{name: "torn level line", content: settings + "\ntile 1 2 3\nlevel 1", wantValid: true,
+			wantTiles: []dziTileKey{{1, 2, 3}}, wantSize: 25},
+		{name: "torn keyword", content: settings + "\ntile 1 2 3\nti", wantValid: true,
+			wantTiles: []dziTileKey{{1, 2, 3}}, wantSize: 25},
+		{name: "only settings", content: settings + "\n", wantValid: true, wantSize: 14},
+		{name: "torn settings", content: settings[:5], wantValid: false},
+		{name: "different settings", content: "other settings\ntile 1 2 3\n", wantValid: false},
+		{name: "empty", content: "", wantValid: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), dziJournalFileName)
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			j := newDZIJournal(path)
+			valid, err := j.read(settings)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if valid != tt.wantValid {
+				t.Fatalf("Got valid %t, want %t", valid, tt.wantValid)
+			}
+			if !valid {
+				return
+			}
+
+			if j.size != tt.wantSize {
+				t.Errorf("Got size %d, want %d", j.size, tt.wantSize)
+			}
+			if len(j.finishedTiles) != len(tt.wantTiles) {
+				t.Errorf("Got tiles %v, want %v", j.finishedTiles, tt.wantTiles)
+			}
+			for _, key := range tt.wantTiles {
+				if !j.TileFinished(key) {
+					t.Errorf("Tile %v is not finished", key)
+				}
+			}
+			if len(j.finishedLevels) != len(tt.wantLevels) {
+				t.Errorf("Got levels %v, want %v", j.finishedLevels, tt.wantLevels)
+			}
+			for _, level := range tt.wantLevels {
+				if !j.LevelFinished(level) {
+					t.Errorf("Level %d is not finished", level)
+				}
+			}
+		})
+	}
+}
+
+func TestDZIJournalAppendAfterTornLine(t *testing.T) {
+	const settings = "test settings"
+
+	path := filepath.Join(t.TempDir(), dziJournalFileName)
+	if err := os.WriteFile(path, []byte(settings+"\ntile 1 2 3\ntile 5 6 1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := openDZIJournal(path, settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := j.FinishTile(dziTileKey{7, 8, 9}); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := settings + "\ntile 1 2 3\ntile 7 8 9\n"; string(data) != want {
+		t.Errorf("Got journal %q, want %q", data, want)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2023-2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package stitch
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ExportDZI exports the stitched image as DZI.
+// The descriptor is written to outputPath, and the tiles into a directory next to it.
+// The progress is reported in tiles.
+//
+// The descriptor is written last, so there is no descriptor if the export fails or ctx is cancelled.
+func ExportDZI(ctx context.Context, stitchedImage *StitchedImage, outputPath string, dziTileSize, dziOverlap int, webPLevel int, progress ProgressFunc) error {
+	descriptorPath := outputPath
+	outputTilesPath := dziTilesPath(outputPath)
+
+	dzi := NewDZI(stitchedImage, dziTileSize, dziOverlap)
+
+	// Create base directory of all DZI files.
+	if err := os.MkdirAll(outputTilesPath, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	// Export DZI tiles.
+	if err := dzi.ExportDZITiles(ctx, outputTilesPath, webPLevel, progress); err != nil {
+		return fmt.Errorf("failed to export DZI tiles: %w", err)
+	}
+
+	// Export DZI descriptor.
+	if err := dzi.ExportDZIDescriptor(ctx, descriptorPath); err != nil {
+		return fmt.Errorf("failed to export DZI descriptor: %w", err)
+	}
+
+	return nil
+}
+
+// ExportDZIShard exports the part of the DZI at outputPath that belongs to the given shard.
+// The progress is reported in tiles.
+//
+// Only the tiles of the highest zoom level are written, the rest of the DZI is created by MergeDZIShards once all shards are finished.
+// If the shards write into different places, the whole tile directory has to be copied together, including the shard journals and the `overlay-free` directory.
+func ExportDZIShard(ctx context.Context, stitchedImage *StitchedImage, outputPath string, shard Shard, dziTileSize, dziOverlap int, webPLevel int, progress ProgressFunc) error {
+	dzi := NewDZI(stitchedImage, dziTileSize, dziOverlap)
+
+	if err := dzi.ExportDZIShard(ctx, dziTilesPath(outputPath), shard, webPLevel, progress); err != nil {
+		return fmt.Errorf("failed to export DZI shard: %w", err)
+	}
+
+	return nil
+}
+
+// MergeDZIShards completes the DZI at outputPath from the tiles of all shardCount shards, and writes its descriptor.
+// The progress is reported in tiles.
+//
+// The stitched image and the DZI settings have to be the same as the ones the shards were exported with.
+func MergeDZIShards(ctx context.Context, stitchedImage *StitchedImage, outputPath string, shardCount int, dziTileSize, dziOverlap int, webPLevel int, progress ProgressFunc) error {
+	dzi := NewDZI(stitchedImage, dziTileSize, dziOverlap)
+
+	if err := dzi.MergeDZIShards(ctx, dziTilesPath(outputPath), shardCount, webPLevel, progress); err != nil {
+		return fmt.Errorf("failed to merge DZI shards: %w", err)
+	}
+
+	if err := dzi.ExportDZIDescriptor(ctx, outputPath); err != nil {
+		return fmt.Errorf("failed to export DZI descriptor: %w", err)
+	}
+
+	return nil
+}
+
+// dziTilesPath returns the path of the tile directory of the DZI at outputPath.
+func dziTilesPath(outputPath string) string {
+	extension := filepath.Ext(outputPath)
+	return strings.TrimSuffix(outputPath, extension) + "_files"
+}
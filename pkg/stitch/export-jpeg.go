@@ -0,0 +1,50 @@
+// Copyright (c) 2023-2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package stitch
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"log"
+)
+
+// ExportJPEG writes the stitched image as JPEG file to outputPath.
+// The progress is reported in pixels.
+//
+// Nothing is written to outputPath if the export fails or ctx is cancelled.
+func ExportJPEG(ctx context.Context, stitchedImage *StitchedImage, outputPath string, progress ProgressFunc) error {
+	log.Printf("Creating output file %q.", outputPath)
+
+	stop := trackProgress(progress, func() (int64, int64) {
+		value, max := stitchedImage.Progress()
+		return int64(value), int64(max)
+	})
+	defer stop()
+
+	// The encoder reads the image pixel by pixel, so provide it with whole rows that are read at once.
+	return writeJPEG(ctx, newStripImage(stitchedImage, stitchedImage.cacheRowHeight), outputPath)
+}
+
+// writeJPEG encodes the given image into a JPEG file at outputPath.
+func writeJPEG(ctx context.Context, img image.Image, outputPath string) error {
+	f, err := createOutputFile(ctx, outputPath)
+	if err != nil {
+		return err
+	}
+	defer f.Discard()
+
+	options := &jpeg.Options{
+		Quality: 80,
+	}
+
+	if err := jpeg.Encode(f, img, options); err != nil {
+		return fmt.Errorf("failed to encode image %q: %w", outputPath, err)
+	}
+
+	return f.Commit()
+}
@@ -0,0 +1,50 @@
+// Copyright (c) 2023-2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package stitch
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"log"
+)
+
+// ExportPNG writes the stitched image as PNG file to outputPath.
+// The progress is reported in pixels.
+//
+// Nothing is written to outputPath if the export fails or ctx is cancelled.
+func ExportPNG(ctx context.Context, stitchedImage *StitchedImage, outputPath string, progress ProgressFunc) error {
+	log.Printf("Creating output file %q.", outputPath)
+
+	stop := trackProgress(progress, func() (int64, int64) {
+		value, max := stitchedImage.Progress()
+		return int64(value), int64(max)
+	})
+	defer stop()
+
+	// The encoder reads the image pixel by pixel, so provide it with whole rows that are read at once.
+	return writePNG(ctx, newStripImage(stitchedImage, stitchedImage.cacheRowHeight), outputPath)
+}
+
+// writePNG encodes the given image into a PNG file at outputPath.
+func writePNG(ctx context.Context, img image.Image, outputPath string) error {
+	f, err := createOutputFile(ctx, outputPath)
+	if err != nil {
+		return err
+	}
+	defer f.Discard()
+
+	encoder := png.Encoder{
+		CompressionLevel: png.DefaultCompression,
+	}
+
+	if err := encoder.Encode(f, img); err != nil {
+		return fmt.Errorf("failed to encode image %q: %w", outputPath, err)
+	}
+
+	return f.Commit()
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package stitch
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+	"log"
+
+	"github.com/Dadido3/go-libwebp/webp"
+)
+
+// ExportWebP writes the stitched image as WebP file to outputPath.
+// The progress is reported in pixels.
+//
+// Nothing is written to outputPath if the export fails or ctx is cancelled.
+func ExportWebP(ctx context.Context, stitchedImage *StitchedImage, outputPath string, webPLevel int, progress ProgressFunc) error {
+	log.Printf("Creating output file %q.", outputPath)
+
+	stop := trackProgress(progress, func() (int64, int64) {
+		value, max := stitchedImage.Progress()
+		return int64(value), int64(max)
+	})
+	defer stop()
+
+	// The encoder needs the whole image in memory anyway, so read it at once.
+	if err := checkWebPSize(stitchedImage.Bounds()); err != nil {
+		return err
+	}
+	img := image.NewRGBA(stitchedImage.Bounds())
+	stitchedImage.ReadRGBA(img)
+
+	return writeWebP(ctx, img, outputPath, webPLevel)
+}
+
+// checkWebPSize returns an error if an image with the given bounds is too large for WebP.
+func checkWebPSize(bounds image.Rectangle) error {
+	if bounds.Dx() > 16383 || bounds.Dy() > 16383 {
+		return fmt.Errorf("image size exceeds the maximum allowed size (16383) of a WebP image: %d x %d", bounds.Dx(), bounds.Dy())
+	}
+
+	return nil
+}
+
+// writeWebP encodes the given image into a WebP file at outputPath.
+func writeWebP(ctx context.Context, img image.Image, outputPath string, webPLevel int) error {
+	if err := checkWebPSize(img.Bounds()); err != nil {
+		return err
+	}
+
+	// The encoder takes RGBA images as they are, but expects colors without premultiplied alpha.
+	// This only makes a difference for images with transparency.
+	if imgRGBA, ok := img.(*image.RGBA); ok && !imgRGBA.Opaque() {
+		imgNRGBA := image.NewNRGBA(imgRGBA.Bounds())
+		draw.Draw(imgNRGBA, imgNRGBA.Bounds(), imgRGBA, imgRGBA.Bounds().Min, draw.Src)
+		img = imgNRGBA
+	}
+
+	f, err := createOutputFile(ctx, outputPath)
+	if err != nil {
+		return err
+	}
+	defer f.Discard()
+
+	webPConfig, err := webp.ConfigLosslessPreset(webPLevel)
+	if err != nil {
+		return fmt.Errorf("failed to create webP config: %v", err)
+	}
+
+	if err = webp.Encode(f, img, webPConfig); err != nil {
+		// The encoder doesn't pass through errors of the writer, so check for cancellation here.
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return fmt.Errorf("failed to encode image %q: %w", outputPath, err)
+	}
+
+	return f.Commit()
+}
@@ -0,0 +1,255 @@
+// Copyright (c) 2019-2022 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package stitch
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/png"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nfnt/resize"
+)
+
+var ImageTileFileRegex = regexp.MustCompile(`^(-?\d+),(-?\d+).png$`)
+
+type ImageTile struct {
+	fileName string
+	modTime  time.Time
+	fileSize int64
+
+	diskCached bool // Whether the decoded image is stored in DefaultDiskCache.
+
+	scaleDivider int // Downscales the coordinates and images on the fly.
+
+	image      image.Image // Either a rectangle or an RGBA image. The bounds of this image are determined by the filename.
+	imageMutex *sync.RWMutex
+	cacheEntry *cacheEntry // The loaded image is managed by DefaultCache.
+}
+
+// NewImageTile returns an image tile object that represents the image at the given path.
+// The filename will be used to determine the top left x and y coordinate of the tile.
+// This will not load the image into RAM.
+func NewImageTile(path string, scaleDivider int) (ImageTile, error) {
+	if scaleDivider < 1 {
+		return ImageTile{}, fmt.Errorf("invalid scale of %v", scaleDivider)
+	}
+
+	baseName := filepath.Base(path)
+	result := ImageTileFileRegex.FindStringSubmatch(baseName)
+	var x, y int
+	if parsed, err := strconv.ParseInt(result[1], 10, 0); err == nil {
+		x = int(parsed)
+	} else {
+		return ImageTile{}, fmt.Errorf("error parsing %q to integer: %w", result[1], err)
+	}
+	if parsed, err := strconv.ParseInt(result[2], 10, 0); err == nil {
+		y = int(parsed)
+	} else {
+		return ImageTile{}, fmt.Errorf("error parsing %q to integer: %w", result[2], err)
+	}
+
+	width, height, err := GetImageFileDimension(path)
+	if err != nil {
+		return ImageTile{}, err
+	}
+
+	var modTime time.Time
+	var fileSize int64
+	fileInfo, err := os.Lstat(path)
+	if err == nil {
+		modTime, fileSize = fileInfo.ModTime(), fileInfo.Size()
+	}
+
+	return ImageTile{
+		fileName:     path,
+		modTime:      modTime,
+		fileSize:     fileSize,
+		diskCached:   true,
+		scaleDivider: scaleDivider,
+		image:        image.Rect(DivideFloor(x, scaleDivider), DivideFloor(y, scaleDivider), DivideCeil(x+width, scaleDivider), DivideCeil(y+height, scaleDivider)),
+		imageMutex:   &sync.RWMutex{},
+		cacheEntry:   &cacheEntry{},
+	}, nil
+}
+
+// GetImage returns an image.Image that contains the tile pixel data.
+// This will not return errors in case something went wrong, but will just return nil.
+// All errors are written to stdout.
+//
+// The image is kept in DefaultCache, until it gets evicted.
+func (it *ImageTile) GetImage() *image.RGBA {
+	it.imageMutex.RLock()
+
+	// Check if the image is already loaded.
+	if img, ok := it.image.(*image.RGBA); ok {
+		it.imageMutex.RUnlock()
+		DefaultCache.hit(it.cacheEntry)
+		return img
+	}
+
+	it.imageMutex.RUnlock()
+
+	imgRGBA, loaded, decoded := it.loadImage()
+	if loaded {
+		// This must be called without holding the lock, as it may evict other tiles.
+		DefaultCache.put(it.cacheEntry, int64(len(imgRGBA.Pix)), decoded, it.evict)
+	} else if imgRGBA != nil {
+		DefaultCache.hit(it.cacheEntry)
+	}
+
+	return imgRGBA
+}
+
+// loadImage decodes the tile image, unless it has been loaded in the meantime.
+// loaded is true if the image got loaded by this call, and decoded is true if it wasn't read from DefaultDiskCache.
+func (it *ImageTile) loadImage() (img *image.RGBA, loaded, decoded bool) {
+	// It's possible that the image got changed in between here.
+	it.imageMutex.Lock()
+	defer it.imageMutex.Unlock()
+
+	// Check again if the image is already loaded.
+	if img, ok := it.image.(*image.RGBA); ok {
+		return img, false, false
+	}
+
+	// Store rectangle of the old image.
+	oldRect := it.image.Bounds()
+
+	diskCache := DefaultDiskCache
+	if !it.diskCached {
+		diskCache = nil
+	}
+
+	var entryPath string
+	if diskCache != nil {
+		entryPath = diskCache.entryPath(it.fileName, it.modTime, it.fileSize, it.scaleDivider)
+		if imgRGBA := diskCache.load(entryPath, oldRect.Size()); imgRGBA != nil {
+			imgRGBA.Rect = imgRGBA.Rect.Add(oldRect.Min)
+			it.image = imgRGBA
+			return imgRGBA, true, false
+		}
+	}
+
+	file, err := os.Open(it.fileName)
+	if err != nil {
+		log.Printf("Couldn't load file %q: %v.", it.fileName, err)
+		return nil, false, false
+	}
+	defer file.Close()
+
+	decodedImage, _, err := image.Decode(file)
+	if err != nil {
+		log.Printf("Couldn't decode image %q: %v.", it.fileName, err)
+		return nil, false, false
+	}
+
+	imgRGBA, err := it.scaleImage(decodedImage, oldRect)
+	if err != nil {
+		log.Printf("Couldn't convert image %q: %v.", it.fileName, err)
+		return nil, false, false
+	}
+
+	if diskCache != nil {
+		if err := diskCache.store(entryPath, imgRGBA); err != nil {
+			log.Printf("Couldn't store image %q in disk cache: %v.", it.fileName, err)
+		}
+	}
+
+	imgRGBA.Rect = imgRGBA.Rect.Add(oldRect.Min)
+
+	it.image = imgRGBA
+
+	return imgRGBA, true, true
+}
+
+// scaleImage scales the decoded image to the size of rect, and returns it as RGBA image with its origin at (0, 0).
+func (it *ImageTile) scaleImage(decoded image.Image, rect image.Rectangle) (*image.RGBA, error) {
+	if it.scaleDivider > 1 {
+		decoded = resize.Resize(uint(rect.Dx()), uint(rect.Dy()), decoded, resize.NearestNeighbor)
+	}
+
+	switch decoded := decoded.(type) {
+	case *image.RGBA:
+		return decoded, nil
+	case *image.NRGBA:
+		bounds := decoded.Bounds()
+		imgRGBA := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		draw.Draw(imgRGBA, imgRGBA.Bounds(), decoded, bounds.Min, draw.Src)
+		return imgRGBA, nil
+	default:
+		return nil, fmt.Errorf("expected an RGBA or NRGBA image, got %T instead", decoded)
+	}
+}
+
+// setImage sets the image of the tile, as if it had been decoded from the tile's file.
+// img has to be the unscaled content of the file, it's scaled like a decoded image.
+//
+// This lets DZI exports pass the tiles of one zoom level to the next, without decoding the files they just wrote.
+// The image is kept in DefaultCache, until it gets evicted.
+func (it *ImageTile) setImage(img *image.RGBA) {
+	// Decoded images have their origin at (0, 0).
+	img = &image.RGBA{Pix: img.Pix, Stride: img.Stride, Rect: image.Rectangle{Max: img.Rect.Size()}}
+
+	it.imageMutex.Lock()
+	rect := it.image.Bounds()
+	imgRGBA, err := it.scaleImage(img, rect)
+	if err != nil {
+		it.imageMutex.Unlock()
+		return
+	}
+	if imgRGBA == img {
+		// Don't keep a reference to the buffer of the caller.
+		imgRGBA = &image.RGBA{Pix: append([]uint8(nil), img.Pix...), Stride: img.Stride, Rect: img.Rect}
+	}
+	imgRGBA.Rect = imgRGBA.Rect.Add(rect.Min)
+	it.image = imgRGBA
+	it.imageMutex.Unlock()
+
+	// This must be called without holding the lock, as it may evict other tiles.
+	DefaultCache.store(it.cacheEntry, int64(len(imgRGBA.Pix)), it.evict)
+}
+
+// evict drops the loaded image after it got evicted from the cache.
+func (it *ImageTile) evict() {
+	it.imageMutex.Lock()
+	defer it.imageMutex.Unlock()
+
+	// The image may have been loaded and cached again in the meantime.
+	if !DefaultCache.contains(it.cacheEntry) {
+		it.image = it.image.Bounds()
+	}
+}
+
+// Invalidate clears the cached image.
+// The image will be loaded again when needed.
+func (it *ImageTile) Invalidate() {
+	DefaultCache.remove(it.cacheEntry)
+
+	it.imageMutex.Lock()
+	defer it.imageMutex.Unlock()
+	it.image = it.image.Bounds()
+}
+
+// The scaled image boundaries.
+// This matches exactly to what GetImage() returns.
+func (it *ImageTile) Bounds() image.Rectangle {
+	it.imageMutex.RLock()
+	defer it.imageMutex.RUnlock()
+
+	return it.image.Bounds()
+}
+
+func (it *ImageTile) String() string {
+	return fmt.Sprintf("{ImageTile: %q}", it.fileName)
+}
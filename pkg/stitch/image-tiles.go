@@ -3,9 +3,10 @@
 // This software is released under the MIT License.
 // https://opensource.org/licenses/MIT
 
-package main
+package stitch
 
 import (
+	"context"
 	"fmt"
 	"image"
 	"path/filepath"
@@ -14,7 +15,8 @@ import (
 type ImageTiles []ImageTile
 
 // LoadImageTiles "loads" all images in the directory at the given path.
-func LoadImageTiles(path string, scaleDivider int) (ImageTiles, error) {
+// This only reads the dimensions of the images, which can still take a while for many tiles, therefore it stops when ctx is cancelled.
+func LoadImageTiles(ctx context.Context, path string, scaleDivider int) (ImageTiles, error) {
 	if scaleDivider < 1 {
 		return nil, fmt.Errorf("invalid scale of %v", scaleDivider)
 	}
@@ -27,6 +29,10 @@ func LoadImageTiles(path string, scaleDivider int) (ImageTiles, error) {
 	}
 
 	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
 		imageTile, err := NewImageTile(file, scaleDivider)
 		if err != nil {
 			return nil, err
@@ -38,7 +44,7 @@ func LoadImageTiles(path string, scaleDivider int) (ImageTiles, error) {
 	return imageTiles, nil
 }
 
-// InvalidateAboveY invalidates all cached images that have no pixel at the given y coordinate or below.
+// Bounds returns the union of the bounds of all tiles.
 func (it ImageTiles) Bounds() image.Rectangle {
 	totalBounds := image.Rectangle{}
 	for i, tile := range it {
@@ -52,12 +58,9 @@ func (it ImageTiles) Bounds() image.Rectangle {
 	return totalBounds
 }
 
-// InvalidateAboveY invalidates all cached images that have no pixel at the given y coordinate or below.
-func (it ImageTiles) InvalidateAboveY(y int) {
+// Invalidate clears the cached images of all tiles.
+func (it ImageTiles) Invalidate() {
 	for i := range it {
-		tile := &it[i] // Need to copy a reference.
-		if tile.Bounds().Max.Y <= y {
-			tile.Invalidate()
-		}
+		it[i].Invalidate()
 	}
 }
@@ -0,0 +1,87 @@
+// Copyright (c) 2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package stitch
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// outputFile is written to a temporary file next to its final path, and only renamed into place once it's complete.
+// This way an interrupted export never leaves truncated files behind.
+type outputFile struct {
+	ctx  context.Context
+	file *os.File
+	path string // The final path of the file.
+}
+
+// createOutputFile creates a temporary file for the output at path.
+// Writes will fail once ctx is cancelled.
+//
+// Either Commit or Discard has to be called, Discard is a no-op after a successful Commit.
+func createOutputFile(ctx context.Context, path string) (*outputFile, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file: %w", err)
+	}
+
+	// CreateTemp only grants access to the current user, which is not what we want for outputs.
+	if err := file.Chmod(0644); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, fmt.Errorf("failed to change file mode: %w", err)
+	}
+
+	return &outputFile{
+		ctx:  ctx,
+		file: file,
+		path: path,
+	}, nil
+}
+
+// Write implements the io.Writer interface.
+func (f *outputFile) Write(p []byte) (int, error) {
+	if err := f.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return f.file.Write(p)
+}
+
+// Commit closes the temporary file and moves it to its final path.
+func (f *outputFile) Commit() error {
+	if err := f.ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := f.file.Close(); err != nil {
+		return fmt.Errorf("failed to close file: %w", err)
+	}
+
+	if err := os.Rename(f.file.Name(), f.path); err != nil {
+		return fmt.Errorf("failed to move file into place: %w", err)
+	}
+
+	f.file = nil
+	return nil
+}
+
+// Discard closes and removes the temporary file, if it hasn't been committed yet.
+func (f *outputFile) Discard() {
+	if f.file == nil {
+		return
+	}
+
+	f.file.Close()
+	os.Remove(f.file.Name())
+	f.file = nil
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package stitch
+
+import (
+	"fmt"
+)
+
+// OverlaySizeUnit defines in which unit an OverlaySize is given.
+type OverlaySizeUnit int
+
+const (
+	OverlaySizeUnitWorld  OverlaySizeUnit = iota // The size is given in world pixels, and will shrink with the output.
+	OverlaySizeUnitScreen                        // The size is given in output pixels, and stays the same regardless of any downscaling.
+)
+
+// ParseOverlaySizeUnit returns the unit that corresponds to the given name.
+func ParseOverlaySizeUnit(name string) (OverlaySizeUnit, error) {
+	switch name {
+	case "world":
+		return OverlaySizeUnitWorld, nil
+	case "screen":
+		return OverlaySizeUnitScreen, nil
+	}
+
+	return 0, fmt.Errorf("unknown overlay size unit %q", name)
+}
+
+// OverlayScale describes the scale an overlay is drawn at.
+type OverlayScale struct {
+	Divider     int // Output coordinates are world coordinates divided by this.
+	SizeDivider int // World unit sizes are divided by this. This is usually the same as Divider, but may be smaller to keep sizes constant across zoom levels.
+}
+
+// NewOverlayScale returns an overlay scale for an output that is downscaled by the given scale divider.
+func NewOverlayScale(scaleDivider int) OverlayScale {
+	return OverlayScale{Divider: scaleDivider, SizeDivider: scaleDivider}
+}
+
+// ZoomOut returns the factor by which the output is zoomed out compared to the output the sizes are meant for.
+// Overlays can use this to draw simplified versions of themselves.
+func (s OverlayScale) ZoomOut() int {
+	return s.Divider / s.SizeDivider
+}
+
+// OverlaySize is a length used to draw overlays, like line widths or marker sizes.
+type OverlaySize struct {
+	Value float64
+	Unit  OverlaySizeUnit
+}
+
+// OutputPixels returns the size in output pixels for the given overlay scale.
+func (s OverlaySize) OutputPixels(scale OverlayScale) float64 {
+	if s.Unit == OverlaySizeUnitScreen {
+		return s.Value
+	}
+
+	return s.Value / float64(scale.SizeDivider)
+}
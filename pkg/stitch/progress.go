@@ -0,0 +1,53 @@
+// Copyright (c) 2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package stitch
+
+import (
+	"time"
+)
+
+// ProgressFunc is called regularly by exporters to report their progress.
+// current and total are given in an exporter specific unit, like pixels or tiles.
+// total may change while the export is running.
+type ProgressFunc func(current, total int64)
+
+// progressInterval is the time between two progress reports.
+const progressInterval = 250 * time.Millisecond
+
+// trackProgress calls progress regularly with the values returned by get, until the returned stop function is called.
+// The stop function reports the progress one last time with current set to total.
+//
+// If progress is nil, nothing is reported.
+func trackProgress(progress ProgressFunc, get func() (current, total int64)) (stop func()) {
+	if progress == nil {
+		return func() {}
+	}
+
+	progress(get())
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				progress(get())
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+		_, total := get()
+		progress(total, total)
+	}
+}
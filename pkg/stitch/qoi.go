@@ -0,0 +1,183 @@
+// Copyright (c) 2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package stitch
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+)
+
+// QOI is a simple lossless image format that is much faster to decode than PNG.
+// It's used to store decoded tiles in the disk cache.
+//
+// Source: https://qoiformat.org/qoi-specification.pdf
+
+const (
+	qoiOpIndex = 0x00 // 00xxxxxx
+	qoiOpDiff  = 0x40 // 01xxxxxx
+	qoiOpLuma  = 0x80 // 10xxxxxx
+	qoiOpRun   = 0xc0 // 11xxxxxx
+	qoiOpRGB   = 0xfe // 11111110
+	qoiOpRGBA  = 0xff // 11111111
+
+	qoiHeaderSize = 14
+)
+
+var qoiPadding = [8]byte{0, 0, 0, 0, 0, 0, 0, 1}
+
+type qoiPixel struct{ r, g, b, a uint8 }
+
+func (p qoiPixel) hash() uint8 {
+	return (p.r*3 + p.g*5 + p.b*7 + p.a*11) % 64
+}
+
+// encodeQOI returns the given image encoded in the QOI format.
+// The pixels are stored as they are, so premultiplied colors stay premultiplied.
+func encodeQOI(img *image.RGBA) []byte {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+
+	data := make([]byte, qoiHeaderSize, qoiHeaderSize+width*height+len(qoiPadding))
+	copy(data, "qoif")
+	binary.BigEndian.PutUint32(data[4:], uint32(width))
+	binary.BigEndian.PutUint32(data[8:], uint32(height))
+	data[12], data[13] = 4, 0 // RGBA, sRGB.
+
+	var index [64]qoiPixel
+	prev := qoiPixel{a: 255}
+	run := 0
+
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(img.Rect.Min.X, y):][:width*4]
+		for i := 0; i < len(row); i += 4 {
+			px := qoiPixel{row[i], row[i+1], row[i+2], row[i+3]}
+
+			if px == prev {
+				run++
+				if run == 62 {
+					data = append(data, qoiOpRun|byte(run-1))
+					run = 0
+				}
+				continue
+			}
+
+			if run > 0 {
+				data = append(data, qoiOpRun|byte(run-1))
+				run = 0
+			}
+
+			hash := px.hash()
+			switch {
+			case index[hash] == px:
+				data = append(data, qoiOpIndex|hash)
+
+			case px.a == prev.a:
+				index[hash] = px
+				vr, vg, vb := int8(px.r-prev.r), int8(px.g-prev.g), int8(px.b-prev.b)
+				vgr, vgb := vr-vg, vb-vg
+				switch {
+				case vr >= -2 && vr <= 1 && vg >= -2 && vg <= 1 && vb >= -2 && vb <= 1:
+					data = append(data, qoiOpDiff|byte(vr+2)<<4|byte(vg+2)<<2|byte(vb+2))
+				case vg >= -32 && vg <= 31 && vgr >= -8 && vgr <= 7 && vgb >= -8 && vgb <= 7:
+					data = append(data, qoiOpLuma|byte(vg+32), byte(vgr+8)<<4|byte(vgb+8))
+				default:
+					data = append(data, qoiOpRGB, px.r, px.g, px.b)
+				}
+
+			default:
+				index[hash] = px
+				data = append(data, qoiOpRGBA, px.r, px.g, px.b, px.a)
+			}
+
+			prev = px
+		}
+	}
+
+	if run > 0 {
+		data = append(data, qoiOpRun|byte(run-1))
+	}
+
+	return append(data, qoiPadding[:]...)
+}
+
+// decodeQOI decodes the given QOI data into an RGBA image with its origin at (0, 0).
+// The image has to be of the given size.
+//
+// The size in the header is checked before anything is allocated, so damaged data can't cause huge allocations.
+func decodeQOI(data []byte, size image.Point) (*image.RGBA, error) {
+	if len(data) < qoiHeaderSize+len(qoiPadding) || string(data[:4]) != "qoif" {
+		return nil, errors.New("invalid QOI header")
+	}
+	width, height := int(binary.BigEndian.Uint32(data[4:])), int(binary.BigEndian.Uint32(data[8:]))
+	if width <= 0 || height <= 0 || width != size.X || height != size.Y {
+		return nil, fmt.Errorf("QOI image size %d x %d doesn't match the expected size %d x %d", width, height, size.X, size.Y)
+	}
+
+	// Every chunk is at least one byte long, and describes at most 62 pixels.
+	chunks := data[qoiHeaderSize : len(data)-len(qoiPadding)]
+	if len(chunks)*62 < width*height {
+		return nil, errors.New("unexpected end of QOI data")
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	var index [64]qoiPixel
+	px := qoiPixel{a: 255}
+	run := 0
+
+	pos := 0
+	for i := 0; i < len(img.Pix); i += 4 {
+		if run > 0 {
+			run--
+		} else {
+			if pos >= len(chunks) {
+				return nil, errors.New("unexpected end of QOI data")
+			}
+			b1 := chunks[pos]
+			pos++
+
+			switch {
+			case b1 == qoiOpRGB:
+				if pos+3 > len(chunks) {
+					return nil, errors.New("unexpected end of QOI data")
+				}
+				px.r, px.g, px.b = chunks[pos], chunks[pos+1], chunks[pos+2]
+				pos += 3
+			case b1 == qoiOpRGBA:
+				if pos+4 > len(chunks) {
+					return nil, errors.New("unexpected end of QOI data")
+				}
+				px = qoiPixel{chunks[pos], chunks[pos+1], chunks[pos+2], chunks[pos+3]}
+				pos += 4
+			case b1&0xc0 == qoiOpIndex:
+				px = index[b1]
+			case b1&0xc0 == qoiOpDiff:
+				px.r += (b1>>4)&0x03 - 2
+				px.g += (b1>>2)&0x03 - 2
+				px.b += b1&0x03 - 2
+			case b1&0xc0 == qoiOpLuma:
+				if pos >= len(chunks) {
+					return nil, errors.New("unexpected end of QOI data")
+				}
+				b2 := chunks[pos]
+				pos++
+				vg := b1&0x3f - 32
+				px.r += vg - 8 + (b2>>4)&0x0f
+				px.g += vg
+				px.b += vg - 8 + b2&0x0f
+			case b1&0xc0 == qoiOpRun:
+				run = int(b1 & 0x3f)
+			}
+
+			index[px.hash()] = px
+		}
+
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = px.r, px.g, px.b, px.a
+	}
+
+	return img, nil
+}
@@ -0,0 +1,202 @@
+// Copyright (c) 2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package stitch
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+// qoiOpCounts returns how often every operation is used in the given QOI data.
+func qoiOpCounts(t *testing.T, data []byte) map[string]int {
+	t.Helper()
+
+	counts := map[string]int{}
+	chunks := data[qoiHeaderSize : len(data)-len(qoiPadding)]
+	for pos := 0; pos < len(chunks); {
+		b1 := chunks[pos]
+		switch {
+		case b1 == qoiOpRGB:
+			counts["rgb"]++
+			pos += 4
+		case b1 == qoiOpRGBA:
+			counts["rgba"]++
+			pos += 5
+		case b1&0xc0 == qoiOpIndex:
+			counts["index"]++
+			pos++
+		case b1&0xc0 == qoiOpDiff:
+			counts["diff"]++
+			pos++
+		case b1&0xc0 == qoiOpLuma:
+			counts["luma"]++
+			pos += 2
+		case b1&0xc0 == qoiOpRun:
+			counts["run"]++
+			pos++
+		}
+	}
+
+	return counts
+}
+
+// newQOITestImage returns an image whose pixels are encoded with every QOI operation.
+// The image is a sub image, so its origin isn't at (0, 0) and its stride is larger than its width.
+func newQOITestImage() *image.RGBA {
+	pixels := []color.RGBA{}
+	for i := 0; i < 150; i++ {
+		pixels = append(pixels, color.RGBA{0, 0, 0, 255}) // A run longer than 62 pixels, which starts with the initial pixel.
+	}
+	pixels = append(pixels,
+		color.RGBA{1, 1, 1, 255},       // Diff.
+		color.RGBA{11, 10, 12, 255},    // Luma.
+		color.RGBA{200, 50, 100, 255},  // RGB.
+		color.RGBA{1, 1, 1, 255},       // Index.
+		color.RGBA{0, 0, 0, 0},         // Index, as the index starts with fully transparent pixels.
+		color.RGBA{0, 0, 0, 0},         // Run.
+		color.RGBA{64, 32, 16, 128},    // RGBA, premultiplied and partially transparent.
+		color.RGBA{65, 33, 17, 128},    // Diff, with alpha.
+		color.RGBA{0, 0, 0, 0},         // Index of a transparent pixel.
+		color.RGBA{200, 50, 100, 255},  // Index, even though the alpha changes.
+		color.RGBA{255, 255, 255, 255}, // RGB.
+		color.RGBA{0, 0, 0, 255},       // Diff with wraparound.
+	)
+	rng := rand.New(rand.NewSource(1))
+	for len(pixels)%64 != 0 {
+		pixels = append(pixels, color.RGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), 255})
+	}
+
+	img := image.NewRGBA(image.Rect(-10, -20, 64+10, len(pixels)/64-20+5))
+	subImg := img.SubImage(image.Rect(0, -20, 64, len(pixels)/64-20)).(*image.RGBA)
+	for i, c := range pixels {
+		subImg.SetRGBA(i%64, i/64-20, c)
+	}
+
+	return subImg
+}
+
+func TestQOIRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	noise := image.NewRGBA(image.Rect(0, 0, 37, 23))
+	rng.Read(noise.Pix)
+
+	tests := []struct {
+		name    string
+		img     *image.RGBA
+		wantOps []string
+	}{
+		{name: "all operations", img: newQOITestImage(), wantOps: []string{"run", "index", "diff", "luma", "rgb", "rgba"}},
+		{name: "single pixel", img: image.NewRGBA(image.Rect(0, 0, 1, 1))},
+		{name: "transparent", img: image.NewRGBA(image.Rect(0, 0, 100, 100))},
+		{name: "noise", img: noise},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := encodeQOI(tt.img)
+
+			counts := qoiOpCounts(t, data)
+			for _, op := range tt.wantOps {
+				if counts[op] == 0 {
+					t.Errorf("The encoded image doesn't use the %s operation, got %v", op, counts)
+				}
+			}
+
+			img, err := decodeQOI(data, tt.img.Rect.Size())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := image.Rect(0, 0, tt.img.Rect.Dx(), tt.img.Rect.Dy()); img.Rect != want {
+				t.Fatalf("Got bounds %v, want %v", img.Rect, want)
+			}
+			for y := 0; y < img.Rect.Dy(); y++ {
+				for x := 0; x < img.Rect.Dx(); x++ {
+					want := tt.img.RGBAAt(tt.img.Rect.Min.X+x, tt.img.Rect.Min.Y+y)
+					if got := img.RGBAAt(x, y); got != want {
+						t.Fatalf("Got pixel %v at (%d, %d), want %v", got, x, y, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestQOIRunLength(t *testing.T) {
+	// Runs are limited to 62 pixels, longer runs have to be split.
+	for _, length := range []int{61, 62, 63, 124, 125} {
+		img := image.NewRGBA(image.Rect(0, 0, length, 1))
+		for i := 3; i < len(img.Pix); i += 4 {
+			img.Pix[i] = 255 // All pixels equal the initial pixel, so they are one run.
+		}
+
+		data := encodeQOI(img)
+		if want := (length + 61) / 62; qoiOpCounts(t, data)["run"] != want {
+			t.Errorf("Got %d runs for %d pixels, want %d", qoiOpCounts(t, data)["run"], length, want)
+		}
+
+		decoded, err := decodeQOI(data, img.Rect.Size())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decoded.Pix, img.Pix) {
+			t.Errorf("Decoded pixels differ for a run of %d pixels", length)
+		}
+	}
+}
+
+func TestQOITruncated(t *testing.T) {
+	img := newQOITestImage()
+	data := encodeQOI(img)
+
+	// Every truncated version of the data has to return an error, and must not panic.
+	for n := 0; n < len(data); n++ {
+		if _, err := decodeQOI(data[:n], img.Rect.Size()); err == nil {
+			t.Errorf("Decoding %d of %d bytes succeeded", n, len(data))
+		}
+	}
+}
+
+func TestQOIInvalid(t *testing.T) {
+	valid := encodeQOI(image.NewRGBA(image.Rect(0, 0, 4, 4)))
+
+	tests := []struct {
+		name   string
+		modify func(data []byte)
+		size   image.Point
+	}{
+		{name: "magic", modify: func(data []byte) { data[0] = 'x' }, size: image.Pt(4, 4)},
+		{name: "zero width", modify: func(data []byte) { data[4], data[5], data[6], data[7] = 0, 0, 0, 0 }, size: image.Pt(0, 4)},
+		{name: "damaged size", modify: func(data []byte) { data[8] = 0xff }, size: image.Pt(4, 4)},
+		{name: "unexpected size", modify: func(data []byte) {}, size: image.Pt(4, 5)},
+		// Damaged data that claims to be huge must be rejected before the image is allocated.
+		{name: "larger than data", modify: func(data []byte) { data[6], data[10] = 0xff, 0xff }, size: image.Pt(0xff04, 0xff04)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := bytes.Clone(valid)
+			tt.modify(data)
+			if _, err := decodeQOI(data, tt.size); err == nil {
+				t.Errorf("Decoding succeeded")
+			}
+		})
+	}
+}
+
+func TestQOIGarbage(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	header := encodeQOI(image.NewRGBA(image.Rect(0, 0, 8, 8)))[:qoiHeaderSize]
+
+	// Random chunks may decode into anything, but they must not panic.
+	for i := 0; i < 1000; i++ {
+		data := append(bytes.Clone(header), make([]byte, rng.Intn(300))...)
+		rng.Read(data[qoiHeaderSize:])
+		decodeQOI(data, image.Pt(8, 8))
+	}
+}
@@ -0,0 +1,229 @@
+// Copyright (c) 2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package stitch
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+)
+
+// ImageShardChunkSize is the width and height of the chunks that image shards are written in.
+// This should be a multiple of the cache row height.
+var ImageShardChunkSize = 1024
+
+// Shard selects a part of an export, so that a big export can be split across several processes or machines.
+//
+// Every shard exports a horizontal band of the output independently, and once all shards are finished a merge step assembles the final output from them.
+// See ExportImageShard and MergeImageShards, or ExportDZIShard and MergeDZIShards.
+type Shard struct {
+	Index int // Zero based index of the shard.
+	Count int // Number of shards the export is split into.
+}
+
+// ParseShard parses a shard in the form `i/n`, where i is the one based index of the shard and n the number of shards.
+func ParseShard(s string) (Shard, error) {
+	var index, count int
+	if _, err := fmt.Sscanf(s, "%d/%d", &index, &count); err != nil {
+		return Shard{}, fmt.Errorf("failed to parse shard %q: %w", s, err)
+	}
+
+	shard := Shard{Index: index - 1, Count: count}
+	if err := shard.validate(); err != nil {
+		return Shard{}, err
+	}
+
+	return shard, nil
+}
+
+// String returns the shard in the form `i/n`.
+func (s Shard) String() string {
+	return fmt.Sprintf("%d/%d", s.Index+1, s.Count)
+}
+
+// validate returns an error if the shard doesn't describe a valid part of an export.
+func (s Shard) validate() error {
+	if s.Count < 1 {
+		return fmt.Errorf("invalid number of shards %d", s.Count)
+	}
+	if s.Index < 0 || s.Index >= s.Count {
+		return fmt.Errorf("shard %v doesn't exist", s)
+	}
+
+	return nil
+}
+
+// rows returns the range [start, end) of the given number of rows that belong to this shard.
+// Every shard gets a contiguous range of about the same size.
+func (s Shard) rows(count int) (start, end int) {
+	return count * s.Index / s.Count, count * (s.Index + 1) / s.Count
+}
+
+// journalFileName returns the name of the journal that records the progress of this shard.
+func (s Shard) journalFileName() string {
+	return fmt.Sprintf("export.shard-%d-of-%d.journal", s.Index+1, s.Count)
+}
+
+// overlayTypes returns the types of the given overlays, separated by spaces.
+func overlayTypes(overlays []StitchedImageOverlay) string {
+	types := make([]string, 0, len(overlays))
+	for _, overlay := range overlays {
+		types = append(types, fmt.Sprintf("%T", overlay))
+	}
+
+	return strings.Join(types, " ")
+}
+
+// imageShardSettings returns a description of all settings that affect the chunks of image shards.
+// Shards can only be merged if this matches.
+func imageShardSettings(stitchedImage *StitchedImage) string {
+	si := stitchedImage
+
+	return fmt.Sprintf("noita-mapcap image shard journal v1: bounds %v, scale divider %d, chunk size %d, blend method %#v, overlays [%s]",
+		si.bounds, si.scaleDivider, ImageShardChunkSize, si.blendMethod, overlayTypes(si.overlays))
+}
+
+// imageShardChunks returns the number of chunk columns and rows of the stitched image.
+func imageShardChunks(stitchedImage *StitchedImage) (columns, rows int) {
+	bounds := stitchedImage.bounds
+	return (bounds.Dx()-1)/ImageShardChunkSize + 1, (bounds.Dy()-1)/ImageShardChunkSize + 1
+}
+
+// ExportImageShard writes the part of the stitched image that belongs to the given shard as PNG chunks into shardDir.
+// The progress is reported in chunks.
+//
+// The chunks are named after their top left corner, like image tiles.
+// All shards of an export can write into the same directory, or into different directories whose contents are copied together afterwards.
+// Once all shards are finished, MergeImageShards assembles the chunks into the final image.
+//
+// Like DZI exports, the finished chunks are recorded in a journal, so that an interrupted shard can be resumed.
+func ExportImageShard(ctx context.Context, stitchedImage *StitchedImage, shardDir string, shard Shard, progress ProgressFunc) error {
+	if err := shard.validate(); err != nil {
+		return err
+	}
+
+	log.Printf("Creating image shard %v in %q.", shard, shardDir)
+
+	if err := os.MkdirAll(shardDir, 0755); err != nil {
+		return fmt.Errorf("failed to create shard directory: %w", err)
+	}
+
+	// The chunks are recorded as tiles of zoom level 0, and the zoom level itself marks the whole shard as finished.
+	journal, err := openDZIJournal(filepath.Join(shardDir, shard.journalFileName()), imageShardSettings(stitchedImage))
+	if err != nil {
+		return err
+	}
+	defer journal.Close()
+	if finished := journal.FinishedTiles(); finished > 0 {
+		log.Printf("Resuming image shard, %d chunks are already finished.", finished)
+	}
+
+	columns, rows := imageShardChunks(stitchedImage)
+	start, end := shard.rows(rows)
+
+	var exportedChunks, failedChunks atomic.Int64
+	stop := trackProgress(progress, func() (int64, int64) { return exportedChunks.Load(), int64((end - start) * columns) })
+	defer stop()
+
+	lg := NewLimitGroup(runtime.NumCPU())
+	for cY := start; cY < end && ctx.Err() == nil; cY++ {
+		for cX := 0; cX < columns && ctx.Err() == nil; cX++ {
+			rect := image.Rect(cX*ImageShardChunkSize, cY*ImageShardChunkSize, (cX+1)*ImageShardChunkSize, (cY+1)*ImageShardChunkSize)
+			rect = rect.Add(stitchedImage.bounds.Min).Intersect(stitchedImage.bounds)
+			img := stitchedImage.SubStitchedImage(rect) // Use the cache rows like a full export, so the overlays are drawn exactly the same.
+			filePath := filepath.Join(shardDir, fmt.Sprintf("%d,%d.png", rect.Min.X, rect.Min.Y))
+
+			key := dziTileKey{X: cX, Y: cY}
+			if journal.TileFinished(key) && fileExists(filePath) {
+				exportedChunks.Add(1)
+				continue
+			}
+
+			lg.Add(1)
+			go func() {
+				defer lg.Done()
+				defer exportedChunks.Add(1)
+
+				imgRGBA := image.NewRGBA(img.Bounds())
+				img.ReadRGBA(imgRGBA)
+				if err := writePNG(ctx, imgRGBA, filePath); err != nil {
+					if ctx.Err() == nil {
+						log.Printf("Failed to export image shard chunk: %v", err)
+						failedChunks.Add(1)
+					}
+					return
+				}
+				if err := journal.FinishTile(key); err != nil {
+					log.Printf("Failed to record image shard chunk: %v", err)
+				}
+			}()
+		}
+	}
+	lg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if failed := failedChunks.Load(); failed > 0 {
+		return fmt.Errorf("failed to export %d chunks", failed)
+	}
+
+	return journal.FinishLevel(0)
+}
+
+// blendMethodShardChunks draws the chunks of image shards.
+// The chunks don't overlap, and they keep the opacity of the image they were exported from.
+type blendMethodShardChunks struct {
+	BlendMethodFast
+	opaque bool
+}
+
+// Opaque returns whether the image the chunks were exported from is opaque.
+func (b blendMethodShardChunks) Opaque() bool {
+	return b.opaque
+}
+
+// MergeImageShards returns a stitched image that is assembled from the chunks that all shardCount shards wrote into shardDir.
+// It can be exported into any format, and must be closed afterwards.
+//
+// stitchedImage has to be set up exactly like the one the shards were exported from, but it isn't read from.
+// An error is returned if any shard isn't finished, or if it was exported with different settings.
+//
+// The merged image is identical to stitchedImage, except for partially transparent pixels, which can be off by a rounding error.
+// This is because PNG chunks store colors with straight alpha.
+func MergeImageShards(ctx context.Context, stitchedImage *StitchedImage, shardDir string, shardCount int) (*StitchedImage, error) {
+	settings := imageShardSettings(stitchedImage)
+	for i := 0; i < shardCount; i++ {
+		shard := Shard{Index: i, Count: shardCount}
+		journal, err := readDZIJournal(filepath.Join(shardDir, shard.journalFileName()), settings)
+		if err != nil {
+			return nil, fmt.Errorf("image shard %v can't be merged: %w", shard, err)
+		}
+		if !journal.LevelFinished(0) {
+			return nil, fmt.Errorf("image shard %v isn't finished", shard)
+		}
+	}
+
+	tiles, err := LoadImageTiles(ctx, shardDir, 1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load image shard chunks: %w", err)
+	}
+
+	columns, rows := imageShardChunks(stitchedImage)
+	if len(tiles) != columns*rows {
+		return nil, fmt.Errorf("expected %d image shard chunks in %q, but found %d", columns*rows, shardDir, len(tiles))
+	}
+
+	blendMethod := blendMethodShardChunks{opaque: stitchedImage.Opaque()}
+	return NewStitchedImage(ctx, tiles, stitchedImage.bounds, blendMethod, stitchedImage.cacheRowHeight, nil, stitchedImage.scaleDivider)
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package stitch
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// copyDir copies all files in srcDir and its subdirectories into dstDir, like copying the output of shards that ran on different machines together.
+func copyDir(t *testing.T, dstDir, srcDir string) {
+	t.Helper()
+
+	for relPath, data := range readDirFiles(t, srcDir) {
+		path := filepath.Join(dstDir, filepath.FromSlash(relPath))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestParseShard(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    Shard
+		wantErr bool
+	}{
+		{name: "single", s: "1/1", want: Shard{Index: 0, Count: 1}},
+		{name: "second of three", s: "2/3", want: Shard{Index: 1, Count: 3}},
+		{name: "zero index", s: "0/3", wantErr: true},
+		{name: "index too large", s: "4/3", wantErr: true},
+		{name: "zero count", s: "1/0", wantErr: true},
+		{name: "missing count", s: "1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseShard(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Got error %v, want error %t", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("Got %#v, want %#v", got, tt.want)
+			}
+			if err == nil && got.String() != tt.s {
+				t.Errorf("Got string %q, want %q", got.String(), tt.s)
+			}
+		})
+	}
+}
+
+func TestImageShards(t *testing.T) {
+	tileDir := writeTestTiles(t, t.TempDir())
+
+	// Use small chunks, so that the test image is split into several rows of chunks.
+	defer func(chunkSize int) { ImageShardChunkSize = chunkSize }(ImageShardChunkSize)
+	ImageShardChunkSize = 64
+
+	wantPath := filepath.Join(t.TempDir(), "output.png")
+	if err := ExportPNG(context.Background(), newTestStitchedImage(t, context.Background(), tileDir, BlendMethodMedian{}, []StitchedImageOverlay{testOverlay{}}), wantPath, nil); err != nil {
+		t.Fatalf("Single export failed: %v", err)
+	}
+	want, err := os.ReadFile(wantPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for shardCount := 1; shardCount <= 3; shardCount++ {
+		t.Run(fmt.Sprintf("%d shards", shardCount), func(t *testing.T) {
+			// Every shard writes into its own directory, which are copied together afterwards.
+			shardDir := t.TempDir()
+			for i := 0; i < shardCount; i++ {
+				dir := t.TempDir()
+				stitchedImage := newTestStitchedImage(t, context.Background(), tileDir, BlendMethodMedian{}, []StitchedImageOverlay{testOverlay{}})
+				if err := ExportImageShard(context.Background(), stitchedImage, dir, Shard{Index: i, Count: shardCount}, nil); err != nil {
+					t.Fatalf("Shard %d failed: %v", i, err)
+				}
+				copyDir(t, shardDir, dir)
+			}
+
+			stitchedImage := newTestStitchedImage(t, context.Background(), tileDir, BlendMethodMedian{}, []StitchedImageOverlay{testOverlay{}})
+			merged, err := MergeImageShards(context.Background(), stitchedImage, shardDir, shardCount)
+			if err != nil {
+				t.Fatalf("Merge failed: %v", err)
+			}
+			defer merged.Close()
+
+			outputPath := filepath.Join(t.TempDir(), "output.png")
+			if err := ExportPNG(context.Background(), merged, outputPath, nil); err != nil {
+				t.Fatalf("Export of the merged image failed: %v", err)
+			}
+			got, err := os.ReadFile(outputPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != string(want) {
+				t.Errorf("The merged PNG differs from the single export")
+			}
+		})
+	}
+}
+
+func TestImageShardsUnfinished(t *testing.T) {
+	tileDir := writeTestTiles(t, t.TempDir())
+
+	shardDir := t.TempDir()
+	stitchedImage := newTestStitchedImage(t, context.Background(), tileDir, BlendMethodMedian{}, nil)
+	if err := ExportImageShard(context.Background(), stitchedImage, shardDir, Shard{Index: 0, Count: 2}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if merged, err := MergeImageShards(context.Background(), stitchedImage, shardDir, 2); err == nil {
+		merged.Close()
+		t.Errorf("Merging with a missing shard succeeded")
+	}
+}
+
+func TestDZIShards(t *testing.T) {
+	tileDir := writeTestTiles(t, t.TempDir())
+
+	tests := []struct {
+		name        string
+		blendMethod StitchedImageBlendMethod
+		overlays    []StitchedImageOverlay
+	}{
+		{name: "without overlays", blendMethod: BlendMethodMedian{}},
+		{name: "with overlays", blendMethod: BlendMethodMedian{}, overlays: []StitchedImageOverlay{testOverlay{}}},
+		{name: "overlay layer", blendMethod: BlendMethodTransparent{}, overlays: []StitchedImageOverlay{testOverlay{}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wantDir := t.TempDir()
+			stitchedImage := newTestStitchedImage(t, context.Background(), tileDir, tt.blendMethod, tt.overlays)
+			if err := ExportDZI(context.Background(), stitchedImage, filepath.Join(wantDir, "output.dzi"), 64, 2, 0, nil); err != nil {
+				t.Fatalf("Single export failed: %v", err)
+			}
+
+			for shardCount := 1; shardCount <= 3; shardCount++ {
+				t.Run(fmt.Sprintf("%d shards", shardCount), func(t *testing.T) {
+					// Every shard writes into its own directory, which are copied together afterwards.
+					// This includes the shard journals and the overlay free tiles.
+					dir := t.TempDir()
+					for i := 0; i < shardCount; i++ {
+						shardDir := t.TempDir()
+						stitchedImage := newTestStitchedImage(t, context.Background(), tileDir, tt.blendMethod, tt.overlays)
+						if err := ExportDZIShard(context.Background(), stitchedImage, filepath.Join(shardDir, "output.dzi"), Shard{Index: i, Count: shardCount}, 64, 2, 0, nil); err != nil {
+							t.Fatalf("Shard %d failed: %v", i, err)
+						}
+						copyDir(t, dir, shardDir)
+					}
+
+					stitchedImage := newTestStitchedImage(t, context.Background(), tileDir, tt.blendMethod, tt.overlays)
+					if err := MergeDZIShards(context.Background(), stitchedImage, filepath.Join(dir, "output.dzi"), shardCount, 64, 2, 0, nil); err != nil {
+						t.Fatalf("Merge failed: %v", err)
+					}
+
+					compareDirs(t, wantDir, dir)
+				})
+			}
+		})
+	}
+}
@@ -3,7 +3,7 @@
 // This software is released under the MIT License.
 // https://opensource.org/licenses/MIT
 
-package main
+package stitch
 
 import (
 	"image"
@@ -13,43 +13,43 @@ import (
 	"sync"
 )
 
-// StitchedImageCache contains part of the actual image data of a stitched image.
+// stitchedImageCache contains part of the actual image data of a stitched image.
 // This can be regenerated or invalidated at will.
-type StitchedImageCache struct {
+type stitchedImageCache struct {
 	sync.Mutex
 
 	stitchedImage *StitchedImage // The parent object.
 
-	rect  image.Rectangle // Position and size of the cached area.
-	image *image.RGBA     // Cached RGBA image. The bounds of this image are determined by the filename.
-
-	idleCounter byte // Is incremented when this cache object idles, and reset every time the cache is used.
+	rect       image.Rectangle // Position and size of the cached area.
+	image      *image.RGBA     // Cached RGBA image. The bounds of this image are determined by the filename.
+	cacheEntry *cacheEntry     // The cached image is managed by DefaultCache.
 }
 
-func NewStitchedImageCache(stitchedImage *StitchedImage, rect image.Rectangle) StitchedImageCache {
-	return StitchedImageCache{
+// newStitchedImageCache returns a cache for the area rect of the given stitched image.
+// The image data is generated on first use.
+func newStitchedImageCache(stitchedImage *StitchedImage, rect image.Rectangle) stitchedImageCache {
+	return stitchedImageCache{
 		stitchedImage: stitchedImage,
 		rect:          rect,
+		cacheEntry:    &cacheEntry{},
 	}
 }
 
-// InvalidateAuto invalidates this cache object when it had idled for too long.
-// The cache will be invalidated after `threshold + 1` calls to InvalidateAuto.
-func (sic *StitchedImageCache) InvalidateAuto(threshold byte) {
+// evict drops the cached image after it got evicted from the cache.
+func (sic *stitchedImageCache) evict() {
 	sic.Lock()
 	defer sic.Unlock()
 
-	if sic.image != nil {
-		if sic.idleCounter >= threshold {
-			sic.image = nil
-			return
-		}
-		sic.idleCounter++
+	// The image may have been generated and cached again in the meantime.
+	if !DefaultCache.contains(sic.cacheEntry) {
+		sic.image = nil
 	}
 }
 
 // Invalidate clears the cached image.
-func (sic *StitchedImageCache) Invalidate() {
+func (sic *stitchedImageCache) Invalidate() {
+	DefaultCache.remove(sic.cacheEntry)
+
 	sic.Lock()
 	defer sic.Unlock()
 	sic.image = nil
@@ -57,21 +57,35 @@ func (sic *StitchedImageCache) Invalidate() {
 
 // Regenerate refills the cache image with valid image data.
 // This will block until there is a valid image, and it will *always* return a valid image.
-func (sic *StitchedImageCache) Regenerate() *image.RGBA {
+//
+// The image is kept in DefaultCache, until it gets evicted.
+func (sic *stitchedImageCache) Regenerate() *image.RGBA {
+	cacheImage, generated := sic.regenerate()
+	if generated {
+		// This must be called without holding the lock, as it may evict other cache images.
+		DefaultCache.put(sic.cacheEntry, int64(len(cacheImage.Pix)), false, sic.evict)
+	} else {
+		DefaultCache.hit(sic.cacheEntry)
+	}
+
+	return cacheImage
+}
+
+// regenerate generates the cache image, unless there is one already.
+// generated is true if the image got generated by this call.
+func (sic *stitchedImageCache) regenerate() (cacheImage *image.RGBA, generated bool) {
 	sic.Lock()
 	defer sic.Unlock()
 
-	sic.idleCounter = 0
-
 	// Check if there is already a cache image.
 	if sic.image != nil {
-		return sic.image
+		return sic.image, false
 	}
 
 	si := sic.stitchedImage
 
 	// Create new image with default background color.
-	cacheImage := image.NewRGBA(sic.rect)
+	cacheImage = image.NewRGBA(sic.rect)
 	draw.Draw(cacheImage, cacheImage.Bounds(), &image.Uniform{colorBackground}, cacheImage.Bounds().Min, draw.Src)
 
 	// List of tiles that intersect with the to be generated cache image.
@@ -92,6 +106,11 @@ func (sic *StitchedImageCache) Regenerate() *image.RGBA {
 		go func() {
 			defer waitGroup.Done()
 			for workload := range workerQueue {
+				// Don't load any more tiles once the stitched image is cancelled, the chunk just keeps the background color.
+				if si.ctx.Err() != nil {
+					continue
+				}
+
 				// List of tiles that intersect with the workload chunk.
 				workloadTiles := []*ImageTile{}
 
@@ -120,23 +139,23 @@ func (sic *StitchedImageCache) Regenerate() *image.RGBA {
 
 	// Draw overlays.
 	for _, overlay := range si.overlays {
-		if overlay != nil {
-			overlay.Draw(cacheImage)
+		if overlay != nil && si.ctx.Err() == nil {
+			overlay.Draw(cacheImage, NewOverlayScale(si.scaleDivider))
 		}
 	}
 
 	// Update cached image.
 	sic.image = cacheImage
-	return cacheImage
+	return cacheImage, true
 }
 
 // Returns the pixel color at x and y.
-func (sic *StitchedImageCache) RGBAAt(x, y int) color.RGBA {
+func (sic *stitchedImageCache) RGBAAt(x, y int) color.RGBA {
 	// Fast path: The image is loaded.
 	sic.Lock()
 	if sic.image != nil {
 		defer sic.Unlock()
-		sic.idleCounter = 0
+		DefaultCache.access(sic.cacheEntry)
 		return sic.image.RGBAAt(x, y)
 	}
 	sic.Unlock()
@@ -147,10 +166,10 @@ func (sic *StitchedImageCache) RGBAAt(x, y int) color.RGBA {
 }
 
 // Returns the pixel color at x and y.
-func (sic *StitchedImageCache) At(x, y int) color.Color {
+func (sic *stitchedImageCache) At(x, y int) color.Color {
 	return sic.RGBAAt(x, y)
 }
 
-func (sic *StitchedImageCache) Bounds() image.Rectangle {
+func (sic *stitchedImageCache) Bounds() image.Rectangle {
 	return sic.rect
 }
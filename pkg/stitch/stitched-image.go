@@ -0,0 +1,325 @@
+// Copyright (c) 2022-2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package stitch
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"sync"
+	"sync/atomic"
+)
+
+// The default background color.
+// We use a non transparent black.
+var colorBackground = color.RGBA{0, 0, 0, 255}
+
+// StitchedImageCacheGridSize defines the worker chunk size when the cache image is regenerated.
+var StitchedImageCacheGridSize = 256
+
+// StitchedImageCacheBlockSize defines the width and height of cache blocks, see CacheLayoutBlocks.
+var StitchedImageCacheBlockSize = 256
+
+// CacheLayout defines how the cached parts of a stitched image are laid out.
+// Every consumer of a stitched image can choose the layout that fits its access pattern best.
+type CacheLayout int
+
+const (
+	CacheLayoutRows   CacheLayout = iota // Rows spanning the whole image width. This is best for reading the image from top to bottom.
+	CacheLayoutBlocks                    // Square blocks. This is best for reading small rectangles in any order, as only the touched blocks are generated.
+)
+
+// StitchedImageBlendMethod defines how tiles are blended together.
+type StitchedImageBlendMethod interface {
+	Draw(tiles []*ImageTile, destImage *image.RGBA) // Draw is called when a new cache image is generated.
+}
+
+// StitchedImageOverlay defines an interface for arbitrary overlays that can be drawn over the stitched image.
+type StitchedImageOverlay interface {
+	// Draw is called when a new cache image is generated.
+	// destImage is in output coordinates, which are world coordinates divided by scale.Divider.
+	Draw(destImage *image.RGBA, scale OverlayScale)
+}
+
+// StitchedImage combines several ImageTile objects into a single RGBA image.
+// The way the images are combined/blended is defined by the blendFunc.
+type StitchedImage struct {
+	ctx context.Context // Once this is cancelled, no more tiles are loaded and cache images only contain the background.
+
+	tiles       ImageTiles
+	bounds      image.Rectangle
+	blendMethod StitchedImageBlendMethod
+	overlays    []StitchedImageOverlay
+
+	scaleDivider int // The factor the world coordinates are divided by to get the coordinates of this image.
+
+	cacheRowHeight  int
+	cacheRows       []stitchedImageCache
+	cacheRowYOffset int // Defines the pixel offset of the first cache row.
+
+	cacheBlocksOnce    sync.Once
+	cacheBlocks        []stitchedImageCache // Square blocks in row-major order, they are created on first use.
+	cacheBlocksColumns int
+
+	oldCacheRowIndex atomic.Int64
+	queryCounter     atomic.Int64
+
+	background sync.WaitGroup // Background goroutines, like the pre generation of cache rows.
+}
+
+// NewStitchedImage creates a new image from several single image tiles.
+//
+// scaleDivider is the factor the world coordinates are divided by to get the coordinates of the given tiles and bounds.
+// It's passed to the overlays, so they can draw in the correct place and size.
+//
+// Once ctx is cancelled, the image stops loading tiles and only returns the background color for any pixel that isn't cached yet.
+// This lets any export that reads the image finish quickly.
+//
+// The cache rows and blocks of the stitched image are kept in DefaultCache.
+// Close has to be called to wait for any background work, and to free them.
+func NewStitchedImage(ctx context.Context, tiles ImageTiles, bounds image.Rectangle, blendMethod StitchedImageBlendMethod, cacheRowHeight int, overlays []StitchedImageOverlay, scaleDivider int) (*StitchedImage, error) {
+	if bounds.Empty() {
+		return nil, fmt.Errorf("given boundaries are empty")
+	}
+	if blendMethod == nil {
+		return nil, fmt.Errorf("no blending method given")
+	}
+	if cacheRowHeight <= 0 {
+		return nil, fmt.Errorf("invalid cache row height of %d pixels", cacheRowHeight)
+	}
+	if scaleDivider < 1 {
+		return nil, fmt.Errorf("invalid scale of %v", scaleDivider)
+	}
+
+	stitchedImage := &StitchedImage{
+		ctx: ctx,
+
+		tiles:       tiles,
+		bounds:      bounds,
+		blendMethod: blendMethod,
+		overlays:    overlays,
+
+		scaleDivider: scaleDivider,
+	}
+
+	// Generate cache image rows.
+	maxRow := (bounds.Dy() - 1) / cacheRowHeight
+	var cacheRows []stitchedImageCache
+	for i := 0; i <= maxRow; i++ {
+		rect := image.Rect(bounds.Min.X, bounds.Min.Y+i*cacheRowHeight, bounds.Max.X, bounds.Min.Y+(i+1)*cacheRowHeight)
+		cacheRows = append(cacheRows, newStitchedImageCache(stitchedImage, rect.Intersect(bounds)))
+	}
+	stitchedImage.cacheRowHeight = cacheRowHeight
+	stitchedImage.cacheRowYOffset = -bounds.Min.Y
+	stitchedImage.cacheRows = cacheRows
+
+	return stitchedImage, nil
+}
+
+// ColorModel returns the Image's color model.
+func (si *StitchedImage) ColorModel() color.Model {
+	return color.RGBAModel
+}
+
+// Bounds returns the domain for which At can return non-zero color.
+// The bounds do not necessarily contain the point (0, 0).
+func (si *StitchedImage) Bounds() image.Rectangle {
+	return si.bounds
+}
+
+// ScaleDivider returns the factor the world coordinates are divided by to get the coordinates of this image.
+func (si *StitchedImage) ScaleDivider() int {
+	return si.scaleDivider
+}
+
+func (si *StitchedImage) At(x, y int) color.Color {
+	return si.RGBAAt(x, y)
+}
+
+// At returns the color of the pixel at (x, y).
+//
+// This is optimized to be read line by line (scanning), it will be much slower with random access.
+//
+// For the `Progress()` method to work correctly, every pixel should be queried exactly once.
+//
+//	At(Bounds().Min.X, Bounds().Min.Y) // returns the top-left pixel of the image.
+//	At(Bounds().Max.X-1, Bounds().Max.Y-1) // returns the bottom-right pixel.
+func (si *StitchedImage) RGBAAt(x, y int) color.RGBA {
+	// Assume that every pixel is only queried once.
+	si.queryCounter.Add(1)
+
+	// Determine the cache rowIndex index.
+	rowIndex := (y + si.cacheRowYOffset) / si.cacheRowHeight
+	if rowIndex < 0 || rowIndex >= len(si.cacheRows) {
+		return colorBackground
+	}
+
+	si.enterCacheRow(rowIndex)
+
+	return si.cacheRows[rowIndex].RGBAAt(x, y)
+}
+
+// enterCacheRow has to be called before the cache row with the given index is read.
+// When the row changed, it starts to pre generate the next row in the background.
+func (si *StitchedImage) enterCacheRow(rowIndex int) {
+	// Check if we advanced/changed the row index.
+	// This doesn't happen a lot, so stuff inside this can be a bit more expensive.
+	if si.oldCacheRowIndex.Load() == int64(rowIndex) || si.oldCacheRowIndex.Swap(int64(rowIndex)) == int64(rowIndex) {
+		return
+	}
+
+	// Pre generate the new row asynchronously.
+	newRowIndex := rowIndex + 1
+	if newRowIndex >= 0 && newRowIndex < len(si.cacheRows) {
+		si.background.Add(1)
+		go func() {
+			defer si.background.Done()
+			si.cacheRows[newRowIndex].Regenerate()
+		}()
+	}
+}
+
+// ReadRGBA copies the pixels of the rectangle dst.Bounds() into dst.
+// Pixels outside of the image are set to the background color.
+//
+// This is a lot faster than reading every pixel with RGBAAt, as it copies whole rows of the cache at once.
+// It's safe to call this from several goroutines at once.
+//
+// For the `Progress()` method to work correctly, every row of the image should be read exactly once.
+// The progress is updated after every row.
+func (si *StitchedImage) ReadRGBA(dst *image.RGBA) {
+	si.readRGBA(dst, CacheLayoutRows)
+}
+
+// readRGBA copies the pixels of the rectangle dst.Bounds() into dst, by using the cache with the given layout.
+func (si *StitchedImage) readRGBA(dst *image.RGBA, cacheLayout CacheLayout) {
+	rect := dst.Bounds()
+	inside := rect.Intersect(si.bounds)
+	if inside != rect {
+		draw.Draw(dst, rect, &image.Uniform{colorBackground}, image.Point{}, draw.Src)
+	}
+	if inside.Empty() {
+		return
+	}
+
+	if cacheLayout == CacheLayoutBlocks {
+		blocks, columns := si.blocks()
+		blockSize := StitchedImageCacheBlockSize
+		minBX, minBY := (inside.Min.X-si.bounds.Min.X)/blockSize, (inside.Min.Y-si.bounds.Min.Y)/blockSize
+		maxBX, maxBY := (inside.Max.X-1-si.bounds.Min.X)/blockSize, (inside.Max.Y-1-si.bounds.Min.Y)/blockSize
+		for bY := minBY; bY <= maxBY; bY++ {
+			for bX := minBX; bX <= maxBX; bX++ {
+				copyRGBA(dst, blocks[bY*columns+bX].Regenerate(), inside)
+			}
+		}
+		si.queryCounter.Add(int64(inside.Dx() * inside.Dy()))
+		return
+	}
+
+	for y := inside.Min.Y; y < inside.Max.Y; {
+		rowIndex := (y + si.cacheRowYOffset) / si.cacheRowHeight
+		si.enterCacheRow(rowIndex)
+		cacheImage := si.cacheRows[rowIndex].Regenerate()
+
+		// Copy all rows that are inside this cache row.
+		rowEnd := min(inside.Max.Y, cacheImage.Rect.Max.Y)
+		for ; y < rowEnd; y++ {
+			dstOffset, srcOffset := dst.PixOffset(inside.Min.X, y), cacheImage.PixOffset(inside.Min.X, y)
+			copy(dst.Pix[dstOffset:dstOffset+inside.Dx()*4], cacheImage.Pix[srcOffset:srcOffset+inside.Dx()*4])
+			si.queryCounter.Add(int64(inside.Dx()))
+		}
+	}
+}
+
+// copyRGBA copies the pixels of src that are inside of rect into dst.
+func copyRGBA(dst, src *image.RGBA, rect image.Rectangle) {
+	rect = rect.Intersect(src.Rect).Intersect(dst.Rect)
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		dstOffset, srcOffset := dst.PixOffset(rect.Min.X, y), src.PixOffset(rect.Min.X, y)
+		copy(dst.Pix[dstOffset:dstOffset+rect.Dx()*4], src.Pix[srcOffset:srcOffset+rect.Dx()*4])
+	}
+}
+
+// blocks returns the cache blocks and the number of block columns.
+// The blocks are created on the first call.
+func (si *StitchedImage) blocks() ([]stitchedImageCache, int) {
+	si.cacheBlocksOnce.Do(func() {
+		blockSize := StitchedImageCacheBlockSize
+		columns, rows := (si.bounds.Dx()-1)/blockSize+1, (si.bounds.Dy()-1)/blockSize+1
+		si.cacheBlocks = make([]stitchedImageCache, 0, columns*rows)
+		for bY := 0; bY < rows; bY++ {
+			for bX := 0; bX < columns; bX++ {
+				rect := image.Rect(0, 0, blockSize, blockSize).Add(si.bounds.Min).Add(image.Pt(bX*blockSize, bY*blockSize))
+				si.cacheBlocks = append(si.cacheBlocks, newStitchedImageCache(si, rect.Intersect(si.bounds)))
+			}
+		}
+		si.cacheBlocksColumns = columns
+	})
+
+	return si.cacheBlocks, si.cacheBlocksColumns
+}
+
+// blockRGBAAt returns the color of the pixel at (x, y) by using the cache blocks.
+func (si *StitchedImage) blockRGBAAt(x, y int) color.RGBA {
+	si.queryCounter.Add(1)
+
+	if !(image.Point{X: x, Y: y}).In(si.bounds) {
+		return colorBackground
+	}
+
+	blocks, columns := si.blocks()
+	bX, bY := (x-si.bounds.Min.X)/StitchedImageCacheBlockSize, (y-si.bounds.Min.Y)/StitchedImageCacheBlockSize
+	return blocks[bY*columns+bX].RGBAAt(x, y)
+}
+
+// Opaque returns whether the image is fully opaque.
+//
+// For more speed and smaller file size, StitchedImage will be marked as non-transparent.
+// This will speed up image saving by 2x, as there is no need to iterate over the whole image just to find a single non opaque pixel.
+// The only exception is when the blend method itself reports that its result isn't opaque.
+func (si *StitchedImage) Opaque() bool {
+	if opaquer, ok := si.blendMethod.(interface{ Opaque() bool }); ok {
+		return opaquer.Opaque()
+	}
+
+	return true
+}
+
+// Progress returns the approximate progress of any process that scans the image from top to bottom.
+func (si *StitchedImage) Progress() (value, max int) {
+	size := si.Bounds().Size()
+
+	return int(si.queryCounter.Load()), size.X * size.Y
+}
+
+// Close waits until any cache row that is currently being generated in the background is done.
+// Afterwards it frees the cache rows and blocks, and the images of all tiles.
+//
+// The stitched image must not be used after it has been closed.
+func (si *StitchedImage) Close() error {
+	si.background.Wait()
+
+	for rowIndex := range si.cacheRows {
+		si.cacheRows[rowIndex].Invalidate()
+	}
+	for blockIndex := range si.cacheBlocks {
+		si.cacheBlocks[blockIndex].Invalidate()
+	}
+	si.tiles.Invalidate()
+
+	return nil
+}
+
+// SubStitchedImage returns an image representing the portion of the image p visible through r.
+// The returned image references to the original stitched image, and therefore reuses its cache.
+func (si *StitchedImage) SubStitchedImage(r image.Rectangle) SubStitchedImage {
+	return SubStitchedImage{
+		StitchedImage: si,
+		bounds:        si.Bounds().Intersect(r),
+	}
+}
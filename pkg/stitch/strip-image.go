@@ -0,0 +1,77 @@
+// Copyright (c) 2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package stitch
+
+import (
+	"image"
+	"image/color"
+)
+
+// stripImage reads a stitched image in horizontal strips with ReadRGBA, and provides the pixels of the current strip.
+//
+// This is meant for encoders that need an image.Image and read it from top to bottom.
+// Reading pixels in any other order is correct, but slow.
+type stripImage struct {
+	stitchedImage *StitchedImage
+	stripHeight   int
+
+	strip *image.RGBA // The rows that are currently loaded.
+}
+
+// newStripImage returns an image that reads stitchedImage in strips of the given height.
+func newStripImage(stitchedImage *StitchedImage, stripHeight int) *stripImage {
+	return &stripImage{
+		stitchedImage: stitchedImage,
+		stripHeight:   stripHeight,
+		strip:         &image.RGBA{},
+	}
+}
+
+// ColorModel returns the Image's color model.
+func (s *stripImage) ColorModel() color.Model {
+	return color.RGBAModel
+}
+
+// Bounds returns the domain for which At can return non-zero color.
+func (s *stripImage) Bounds() image.Rectangle {
+	return s.stitchedImage.Bounds()
+}
+
+// Opaque returns whether the image is fully opaque.
+// Encoders use this to skip scanning the whole image for transparent pixels.
+func (s *stripImage) Opaque() bool {
+	return s.stitchedImage.Opaque()
+}
+
+func (s *stripImage) At(x, y int) color.Color {
+	return s.RGBAAt(x, y)
+}
+
+// RGBAAt returns the color of the pixel at (x, y).
+// If y is outside of the current strip, the strip containing y is read.
+func (s *stripImage) RGBAAt(x, y int) color.RGBA {
+	point := image.Point{X: x, Y: y}
+	if !point.In(s.strip.Rect) {
+		bounds := s.Bounds()
+		if !point.In(bounds) {
+			return colorBackground
+		}
+
+		// Strips are aligned to the top of the image.
+		minY := bounds.Min.Y + (y-bounds.Min.Y)/s.stripHeight*s.stripHeight
+		rect := image.Rect(bounds.Min.X, minY, bounds.Max.X, minY+s.stripHeight).Intersect(bounds)
+
+		// Reuse the buffer of the previous strip.
+		if len(s.strip.Pix) >= rect.Dx()*rect.Dy()*4 {
+			s.strip = &image.RGBA{Pix: s.strip.Pix[:rect.Dx()*rect.Dy()*4], Stride: rect.Dx() * 4, Rect: rect}
+		} else {
+			s.strip = image.NewRGBA(rect)
+		}
+		s.stitchedImage.ReadRGBA(s.strip)
+	}
+
+	return s.strip.RGBAAt(x, y)
+}
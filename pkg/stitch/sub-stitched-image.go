@@ -0,0 +1,66 @@
+// Copyright (c) 2023-2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package stitch
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+type SubStitchedImage struct {
+	*StitchedImage // The original stitched image.
+
+	bounds      image.Rectangle // The new bounds of the cropped image.
+	cacheLayout CacheLayout     // The cache layout that is used to read the original stitched image.
+}
+
+// WithCacheLayout returns a copy of the sub image, that reads the original stitched image by using the cache with the given layout.
+// By default, the cache rows are used.
+func (s SubStitchedImage) WithCacheLayout(cacheLayout CacheLayout) SubStitchedImage {
+	s.cacheLayout = cacheLayout
+	return s
+}
+
+// Bounds returns the domain for which At can return non-zero color.
+// The bounds do not necessarily contain the point (0, 0).
+func (s SubStitchedImage) Bounds() image.Rectangle {
+	return s.bounds
+}
+
+func (s SubStitchedImage) At(x, y int) color.Color {
+	return s.RGBAAt(x, y)
+}
+
+func (s SubStitchedImage) RGBAAt(x, y int) color.RGBA {
+	point := image.Point{X: x, Y: y}
+	if !point.In(s.bounds) {
+		return colorBackground
+	}
+
+	if s.cacheLayout == CacheLayoutBlocks {
+		return s.StitchedImage.blockRGBAAt(x, y)
+	}
+
+	return s.StitchedImage.RGBAAt(x, y)
+}
+
+// ReadRGBA copies the pixels of the rectangle dst.Bounds() into dst.
+// Pixels outside of the sub image are set to the background color.
+//
+// See StitchedImage.ReadRGBA for details.
+func (s SubStitchedImage) ReadRGBA(dst *image.RGBA) {
+	rect := dst.Bounds()
+	inside := rect.Intersect(s.bounds)
+	if inside != rect {
+		draw.Draw(dst, rect, &image.Uniform{colorBackground}, image.Point{}, draw.Src)
+	}
+	if inside.Empty() {
+		return
+	}
+
+	s.StitchedImage.readRGBA(dst.SubImage(inside).(*image.RGBA), s.cacheLayout)
+}
@@ -3,7 +3,7 @@
 // This software is released under the MIT License.
 // https://opensource.org/licenses/MIT
 
-package main
+package stitch
 
 import (
 	"fmt"
@@ -46,6 +46,39 @@ func QuickSelectUInt8(list []uint8, k int) uint8 {
 	}
 }
 
+// MedianUInt8 returns the median of the given list.
+// For an even number of elements, the rounded down mean of the two middle elements is returned.
+// The list will be reordered, and it must not be empty.
+//
+// Short lists, like the colors of a few overlapping tiles, are sorted with insertion sort.
+// This is faster than running QuickSelectUInt8 once or twice, especially if most of the values are equal.
+func MedianUInt8(list []uint8) uint8 {
+	count := len(list)
+	switch {
+	case count == 2: // The most common overlap.
+		return uint8((int(list[0]) + int(list[1])) / 2)
+	case count > 16:
+		if count%2 == 0 {
+			return uint8((int(QuickSelectUInt8(list, count/2-1)) + int(QuickSelectUInt8(list, count/2))) / 2)
+		}
+		return QuickSelectUInt8(list, count/2)
+	}
+
+	// Insertion sort.
+	for i := 1; i < count; i++ {
+		v, j := list[i], i
+		for ; j > 0 && list[j-1] > v; j-- {
+			list[j] = list[j-1]
+		}
+		list[j] = v
+	}
+
+	if count%2 == 0 {
+		return uint8((int(list[count/2-1]) + int(list[count/2])) / 2)
+	}
+	return list[count/2]
+}
+
 // Source: https://gist.github.com/sergiotapia/7882944
 func GetImageFileDimension(imagePath string) (int, int, error) {
 	file, err := os.Open(imagePath)
@@ -138,3 +171,9 @@ func (lg *LimitGroup) Done() {
 }
 
 func (lg *LimitGroup) Wait() { lg.wg.Wait() }
+
+// fileExists returns whether there is a regular file at the given path.
+func fileExists(path string) bool {
+	fileInfo, err := os.Stat(path)
+	return err == nil && fileInfo.Mode().IsRegular()
+}
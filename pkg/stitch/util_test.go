@@ -0,0 +1,220 @@
+// Copyright (c) 2024 David Vogel
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package stitch
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math/rand"
+	"slices"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// quickSelectMedianUInt8 returns the median of the given list like the quickselect code of blendMethodMedianQuickSelect, by running QuickSelectUInt8 once or twice.
+// The list will be reordered.
+func quickSelectMedianUInt8(list []uint8) uint8 {
+	count := len(list)
+	if count%2 == 0 {
+		return uint8((int(QuickSelectUInt8(list, count/2-1)) + int(QuickSelectUInt8(list, count/2))) / 2)
+	}
+	return QuickSelectUInt8(list, count/2)
+}
+
+func TestMedianUInt8(t *testing.T) {
+	tests := []struct {
+		name string
+		list []uint8
+		want uint8
+	}{
+		{name: "single", list: []uint8{7}, want: 7},
+		{name: "two", list: []uint8{10, 3}, want: 6},
+		{name: "two maximum", list: []uint8{255, 255}, want: 255},
+		{name: "odd", list: []uint8{9, 1, 5}, want: 5},
+		{name: "even", list: []uint8{4, 1, 8, 3}, want: 3},
+		{name: "equal", list: []uint8{2, 2, 2, 2, 2}, want: 2},
+		{name: "quickselect odd", list: []uint8{17, 16, 15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1}, want: 9},
+		{name: "quickselect even", list: []uint8{18, 17, 16, 15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1}, want: 9},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MedianUInt8(slices.Clone(tt.list)); got != tt.want {
+				t.Errorf("Got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMedianUInt8Random(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	for count := 1; count <= 32; count++ {
+		for i := 0; i < 1000; i++ {
+			// Use few different values every other run, so that there are many equal elements.
+			maxValue := 256
+			if i%2 == 1 {
+				maxValue = 4
+			}
+			list := make([]uint8, count)
+			for j := range list {
+				list[j] = uint8(rng.Intn(maxValue))
+			}
+
+			want := quickSelectMedianUInt8(slices.Clone(list))
+			if got := MedianUInt8(slices.Clone(list)); got != want {
+				t.Fatalf("Got %d for %v, want %d", got, list, want)
+			}
+		}
+	}
+}
+
+// blendMethodMedianQuickSelect is a copy of BlendMethodMedian from before MedianUInt8 was added.
+// It reads the pixels with RGBAAt, and selects the median of every channel by running QuickSelectUInt8 once or twice.
+type blendMethodMedianQuickSelect struct {
+	BlendTileLimit int
+}
+
+func (b blendMethodMedianQuickSelect) Draw(tiles []*ImageTile, destImage *image.RGBA) {
+	bounds := destImage.Bounds()
+
+	if b.BlendTileLimit > 0 {
+		// Sort tiles by date.
+		sort.Slice(tiles, func(i, j int) bool { return tiles[i].modTime.After(tiles[j].modTime) })
+	}
+
+	// List of images corresponding with every tile.
+	// Can contain empty/nil entries for images that failed to load.
+	images := []*image.RGBA{}
+	for _, tile := range tiles {
+		images = append(images, tile.GetImage())
+	}
+
+	// Create arrays to be reused every pixel.
+	rListEmpty, gListEmpty, bListEmpty := make([]uint8, 0, len(tiles)), make([]uint8, 0, len(tiles)), make([]uint8, 0, len(tiles))
+
+	for iy := bounds.Min.Y; iy < bounds.Max.Y; iy++ {
+		for ix := bounds.Min.X; ix < bounds.Max.X; ix++ {
+			rList, gList, bList := rListEmpty, gListEmpty, bListEmpty
+			point := image.Point{ix, iy}
+			count := 0
+
+			// Iterate through all images and create a list of colors.
+			for _, img := range images {
+				if img != nil {
+					if point.In(img.Bounds()) {
+						col := img.RGBAAt(point.X, point.Y)
+						rList, gList, bList = append(rList, col.R), append(gList, col.G), append(bList, col.B)
+						count++
+						// Limit number of tiles to median blend.
+						// Will be ignored if the blend tile limit is 0.
+						if count == b.BlendTileLimit {
+							break
+						}
+					}
+				}
+			}
+
+			switch count {
+			case 0: // If there were no images to get data from, ignore the pixel.
+				continue
+
+			case 1: // Only a single tile for this pixel.
+				r, g, b := uint8(rList[0]), uint8(gList[0]), uint8(bList[0])
+				destImage.SetRGBA(ix, iy, color.RGBA{r, g, b, 255})
+
+			default: // Multiple overlapping tiles, median blend them.
+				var r, g, b uint8
+				switch count % 2 {
+				case 0: // Even.
+					r = uint8((int(QuickSelectUInt8(rList, count/2-1)) + int(QuickSelectUInt8(rList, count/2))) / 2)
+					g = uint8((int(QuickSelectUInt8(gList, count/2-1)) + int(QuickSelectUInt8(gList, count/2))) / 2)
+					b = uint8((int(QuickSelectUInt8(bList, count/2-1)) + int(QuickSelectUInt8(bList, count/2))) / 2)
+				default: // Odd.
+					r = QuickSelectUInt8(rList, count/2)
+					g = QuickSelectUInt8(gList, count/2)
+					b = QuickSelectUInt8(bList, count/2)
+				}
+				destImage.SetRGBA(ix, iy, color.RGBA{r, g, b, 255})
+			}
+		}
+	}
+}
+
+func TestBlendMethodMedian(t *testing.T) {
+	for _, limit := range []int{0, 1, 2, 3} {
+		t.Run(fmt.Sprintf("limit %d", limit), func(t *testing.T) {
+			// Shift the tiles, so that pixels are covered by a different number of tiles.
+			tiles := newBenchmarkTiles(5, false)
+			bounds := image.Rectangle{}
+			for i, tile := range tiles {
+				img := tile.image.(*image.RGBA)
+				img.Rect = img.Rect.Add(image.Pt(i*100, i*50))
+				tile.modTime = time.Unix(int64(i*7%5), 0)
+				bounds = bounds.Union(img.Rect)
+			}
+			bounds.Max.X += 10
+
+			want, got := image.NewRGBA(bounds), image.NewRGBA(bounds)
+			blendMethodMedianQuickSelect{BlendTileLimit: limit}.Draw(tiles, want)
+			BlendMethodMedian{BlendTileLimit: limit}.Draw(tiles, got)
+			if !slices.Equal(got.Pix, want.Pix) {
+				t.Errorf("The result differs from the previous implementation")
+			}
+		})
+	}
+}
+
+// newBenchmarkTiles returns the given number of loaded 512x512 tiles that completely overlap each other.
+// If noise is true, all pixels are random.
+// Otherwise most pixels are equal in all tiles, like in real captures, and only a few are random.
+func newBenchmarkTiles(count int, noise bool) []*ImageTile {
+	rng := rand.New(rand.NewSource(int64(count)))
+
+	tiles := make([]*ImageTile, count)
+	for i := range tiles {
+		img := image.NewRGBA(image.Rect(0, 0, 512, 512))
+		for j := 0; j < len(img.Pix); j += 4 {
+			pixel := j / 4
+			img.Pix[j], img.Pix[j+1], img.Pix[j+2], img.Pix[j+3] = uint8(pixel), uint8(pixel/512), uint8(pixel*7), 255
+			if noise || rng.Intn(8) == 0 {
+				img.Pix[j], img.Pix[j+1], img.Pix[j+2] = uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256))
+			}
+		}
+		tiles[i] = &ImageTile{image: img, imageMutex: &sync.RWMutex{}, cacheEntry: &cacheEntry{}}
+	}
+
+	return tiles
+}
+
+// BenchmarkBlendMethodMedian compares BlendMethodMedian with the previous implementation that used QuickSelectUInt8.
+func BenchmarkBlendMethodMedian(b *testing.B) {
+	for _, noise := range []bool{true, false} {
+		name := "agree"
+		if noise {
+			name = "noise"
+		}
+
+		for _, overlaps := range []int{2, 3, 4, 8, 16} {
+			tiles := newBenchmarkTiles(overlaps, noise)
+			destImage := image.NewRGBA(image.Rect(0, 0, 512, 512))
+
+			b.Run(fmt.Sprintf("%s/%d/QuickSelect", name, overlaps), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					blendMethodMedianQuickSelect{}.Draw(tiles, destImage)
+				}
+			})
+			b.Run(fmt.Sprintf("%s/%d/MedianUInt8", name, overlaps), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					BlendMethodMedian{}.Draw(tiles, destImage)
+				}
+			})
+		}
+	}
+}